@@ -132,6 +132,26 @@ func Instances() []*Instance {
 // Servers returns the ServerListeners in i.
 func (i *Instance) Servers() []ServerListener { return i.servers }
 
+// ServerListenAddrs returns the addresses that the servers of
+// i listen on, keyed by the names srv0, srv1 and so on, in
+// the order of Servers. It implements RunningConfig.
+func (i *Instance) ServerListenAddrs() map[string][]string {
+	if i == nil {
+		return nil
+	}
+	addrs := make(map[string][]string, len(i.servers))
+	for idx, s := range i.servers {
+		var listen []string
+		for _, addr := range []net.Addr{s.Addr(), s.LocalAddr()} {
+			if addr != nil {
+				listen = append(listen, addr.String())
+			}
+		}
+		addrs["srv"+strconv.Itoa(idx)] = listen
+	}
+	return addrs
+}
+
 // Stop stops all servers contained in i. It does NOT
 // execute shutdown callbacks.
 func (i *Instance) Stop() error {
@@ -529,51 +529,10 @@ func LoadEnvFromFile(envFile string) error {
 	return nil
 }
 
-// ParseEnvFile implements parse logic for environment files
+// ParseEnvFile implements parse logic for environment files.
+// It is the same as caddy.ParseEnvFile.
 func ParseEnvFile(envInput io.Reader) (map[string]string, error) {
-	envMap := make(map[string]string)
-
-	scanner := bufio.NewScanner(envInput)
-	var line string
-	lineNumber := 0
-
-	for scanner.Scan() {
-		line = strings.TrimSpace(scanner.Text())
-		lineNumber++
-
-		// skip lines starting with comment
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// skip empty line
-		if len(line) == 0 {
-			continue
-		}
-
-		fields := strings.SplitN(line, "=", 2)
-		if len(fields) != 2 {
-			return nil, fmt.Errorf("Can't parse line %d; line should be in KEY=VALUE format", lineNumber)
-		}
-
-		if strings.Contains(fields[0], " ") {
-			return nil, fmt.Errorf("Can't parse line %d; KEY contains whitespace", lineNumber)
-		}
-
-		key := fields[0]
-		val := fields[1]
-
-		if key == "" {
-			return nil, fmt.Errorf("Can't parse line %d; KEY can't be empty string", lineNumber)
-		}
-		envMap[key] = val
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	return envMap, nil
+	return caddy.ParseEnvFile(envInput)
 }
 
 const appName = "Caddy"
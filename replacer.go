@@ -0,0 +1,1766 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Replacer can replace placeholders in strings with
+// values. Unlike the replacer in the httpserver
+// package, it is not tied to a request: values come
+// from static variables created with Set and from
+// any number of providers added with Map.
+type Replacer interface {
+	Set(variable, value string)
+	Delete(variable string)
+	Map(ReplacementFunc)
+	MapErr(ReplacementErrFunc)
+	MapIf(cond func() bool, mapFunc ReplacementFunc)
+	MapNamed(name string, mapFunc ReplacementFunc)
+	MapPriority(mapFunc ReplacementFunc, priority int)
+	Clone() Replacer
+	RemoveMapping(name string)
+	ReplaceAll(input, empty string) string
+	ReplaceAllErr(input, empty string) (string, error)
+	ReplaceAllTo(buf *bytes.Buffer, input, empty string)
+	ReplaceAllBytes(input []byte, empty string) []byte
+	ReplaceStream(in io.Reader, w io.Writer, empty string) error
+	ReplaceAllWith(input, empty string, overlay map[string]string) string
+	ReplaceKnown(input, empty string) string
+	ReplaceAllSimple(input string) string
+	ReplaceRange(input string, start, end int, empty string) string
+	ReplaceOrErr(input string, errOnEmpty, errOnUnknown bool) (string, error)
+	ReplaceAllEscaped(input, empty, contentType string) string
+	ReplaceAllContext(ctx context.Context, input, empty string) (string, error)
+	MapEnumerable(EnumerableProvider)
+	Keys() []string
+	AsMap() map[string]string
+	Range(fn func(key, val string) bool)
+	MapTyped(TypedReplacementFunc)
+	ResolveTyped(key string) (interface{}, bool)
+	EnableCommandDefaults(runner CommandRunner, allowed ...string)
+	EnableInterning(max int)
+	EnableRecursion(maxDepth int)
+	EnableCaseInsensitiveKeys()
+	SetMetricsSink(MetricsSink)
+	SetSlowThreshold(threshold time.Duration, logger *log.Logger)
+	Literal(s string) string
+	ReleaseLiteral(placeholder string)
+	Bake(input, empty string) string
+	BakeStrict(input string) (string, error)
+	DeprecateKey(oldKey, newKey string)
+	RememberKey(key string, depth int)
+	CollapseUnknown(marker string)
+	TransformUnknown(fn func(placeholder string) string)
+	GetMany(keys ...string) (values map[string]string, missing []string)
+	Get(key string) (interface{}, bool)
+	GetString(key string) (string, bool)
+	UnresolvedChan() <-chan string
+	AsExpandFunc() func(string) string
+	FuncMap() template.FuncMap
+	ReplaceBestEffort(input string) (string, []error)
+}
+
+// NewReplacer returns a new Replacer. Static values
+// made with Set take precedence over the default
+// replacements (env.*, system.*, time.now and others),
+// which take precedence over providers added later
+// with Map.
+func NewReplacer() Replacer {
+	rep := &replacer{
+		static: make(map[string]string),
+	}
+	rep.addProvider(rep.staticProvider())
+	rep.addProvider(provider{replace: defaultReplacements, source: SourceDefault})
+	return rep
+}
+
+// NewReplacerWithDelims is like NewReplacer, but placeholders
+// are delimited by opener and closer, which may be longer than
+// one character, e.g. [[key]], instead of braces. This avoids
+// collisions with templates of other systems that use braces.
+// If either delimiter is empty, braces are used.
+func NewReplacerWithDelims(opener, closer string) Replacer {
+	rep := NewReplacer().(*replacer)
+	if opener != "" && closer != "" {
+		rep.opener, rep.closer = opener, closer
+	}
+	return rep
+}
+
+// NewEmptyReplacer returns a Replacer without any providers,
+// not even the default replacements, so that no values from
+// the environment or the file system can get into templates
+// unless providers for them are added with Map. Static values
+// can be made with Set; the provider of static values is only
+// added by the first call to Set, ahead of other providers of
+// the same priority, as in a replacer made by NewReplacer.
+func NewEmptyReplacer() Replacer {
+	return &replacer{
+		static:     make(map[string]string),
+		lazyStatic: true,
+	}
+}
+
+// NewFuncReplacer returns a Replacer whose only provider
+// is f. It has no default replacements and no static
+// values: Set and Delete do nothing.
+func NewFuncReplacer(f ReplacementFunc) Replacer {
+	rep := new(replacer)
+	rep.Map(f)
+	return rep
+}
+
+// NewReplacerFromResolver returns a Replacer which resolves
+// keys with res, which may be backed by anything from a map
+// to a database or a remote service. Like a replacer made
+// with NewFuncReplacer, it has no default replacements and
+// no static values.
+func NewReplacerFromResolver(res Resolver) Replacer {
+	return NewFuncReplacer(res.Resolve)
+}
+
+// NewChainReplacer returns a Replacer which resolves each
+// key from the first of scopes that has a value for it, so
+// that nested scopes of configuration, such as route, site
+// and global, can be given innermost first. The scopes are
+// consulted at replace-time, so values set on them later
+// are seen. Like a replacer made with NewFuncReplacer, the
+// chain has no default replacements or static values of its
+// own; those of the scopes apply.
+func NewChainReplacer(scopes ...Replacer) Replacer {
+	return NewFuncReplacer(func(key string) (string, bool) {
+		for _, scope := range scopes {
+			if val, ok := scope.GetString(key); ok {
+				return val, true
+			}
+		}
+		return "", false
+	})
+}
+
+// replacer implements Replacer. Providers are
+// consulted in order of priority, then in the order
+// they were added; the first one to recognize a key
+// supplies its value.
+//
+// Values, literals and providers may be added and
+// removed while other goroutines replace; mu guards
+// them, and it is never held while a provider is
+// called. Other options, like EnableInterning, must
+// be set before r is shared.
+type replacer struct {
+	mu          sync.RWMutex
+	providers   []provider
+	enumerables []Enumerable
+	static      map[string]string
+	lazyStatic  bool
+	cmdRunner   CommandRunner
+	cmdAllowed  map[string]struct{}
+	interner    *interner
+	metrics     MetricsSink
+	literals    map[string]string
+	literalSeq  uint64
+	deprecated  map[string]string
+	warned      sync.Map
+	history     *keyHistory
+
+	opener, closer string
+	expandDepth    int
+	foldKeys       bool
+	slowThreshold  time.Duration
+	slowLogger     *log.Logger
+
+	unknownMarker    string
+	unknownTransform func(string) string
+	unresolved       chan string
+}
+
+// provider is a source of values of a replacer.
+type provider struct {
+	// replace looks up the value of a key
+	replace ReplacementErrFunc
+
+	// typed, if set, looks up the value of a
+	// key as its native Go type
+	typed TypedReplacementFunc
+
+	// source, if set, names the provider
+	source string
+
+	// name, if set, is the name the provider
+	// was added with by MapNamed
+	name string
+
+	// priority orders the providers: those with higher
+	// priorities are consulted first
+	priority int
+
+	// rebind, if set, returns the replace func of the
+	// provider for another replacer, for providers that
+	// read the state of the replacer they belong to
+	rebind func(*replacer) ReplacementErrFunc
+}
+
+// lookupScope changes how the providers
+// are consulted for a lookup.
+type lookupScope struct {
+	// typed, if set, is set to the value, which for a
+	// typed provider is looked up with its typed func to
+	// keep its type, and formatted with fmt.Sprint for
+	// the string result
+	typed *interface{}
+
+	// static, if set, has the static values to use in
+	// place of those of the static provider, so that the
+	// provider does not take the lock of the replacer
+	static map[string]string
+}
+
+// call looks up key with p, within scope.
+func (p provider) call(key string, scope *lookupScope) (string, bool, error) {
+	if scope == nil {
+		return p.replace(key)
+	}
+	if scope.static != nil && p.source == SourceStatic {
+		val, ok := scope.static[key]
+		if ok && scope.typed != nil {
+			*scope.typed = val
+		}
+		return val, ok, nil
+	}
+	if scope.typed == nil {
+		return p.replace(key)
+	}
+	if p.typed == nil {
+		val, ok, err := p.replace(key)
+		if ok && err == nil {
+			*scope.typed = val
+		}
+		return val, ok, err
+	}
+	val, ok := p.typed(key)
+	if !ok {
+		return "", false, nil
+	}
+	*scope.typed = val
+	return fmt.Sprint(val), true, nil
+}
+
+// Sources of values reported by Resolvability,
+// besides those of enumerable providers.
+const (
+	SourceStatic  = "static"
+	SourceDefault = "default"
+)
+
+// Map adds mapFunc to the list of value providers.
+// mapFunc will be executed only at replace-time.
+func (r *replacer) Map(mapFunc ReplacementFunc) {
+	r.MapErr(infallible(mapFunc))
+}
+
+// infallible adapts mapFunc to a ReplacementErrFunc.
+func infallible(mapFunc ReplacementFunc) ReplacementErrFunc {
+	return func(key string) (string, bool, error) {
+		val, ok := mapFunc(key)
+		return val, ok, nil
+	}
+}
+
+// MapErr is like Map, but for a provider that can fail,
+// such as one backed by a remote secret store. A provider
+// that fails stops the lookup of the key: later providers
+// are not consulted for it, and the key is treated as
+// unknown. Methods that return errors, like ReplaceAllErr,
+// report the failure.
+func (r *replacer) MapErr(mapFunc ReplacementErrFunc) {
+	r.addProvider(provider{replace: mapFunc})
+}
+
+// addProvider adds p to the providers of r, after
+// all providers with the same or a higher priority.
+func (r *replacer) addProvider(p provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addProviderLocked(p)
+}
+
+// addProviderLocked is like addProvider, but the
+// caller must hold the write lock.
+func (r *replacer) addProviderLocked(p provider) {
+	idx := len(r.providers)
+	for idx > 0 && r.providers[idx-1].priority < p.priority {
+		idx--
+	}
+	r.insertProvider(idx, p)
+}
+
+// insertProvider inserts p into the providers of r at
+// idx. The caller must hold the write lock.
+func (r *replacer) insertProvider(idx int, p provider) {
+	if idx == len(r.providers) {
+		r.providers = append(r.providers, p)
+		return
+	}
+	providers := make([]provider, 0, len(r.providers)+1)
+	providers = append(providers, r.providers[:idx]...)
+	providers = append(providers, p)
+	r.providers = append(providers, r.providers[idx:]...)
+}
+
+// providerList returns the current providers of r. The
+// slice can be used without holding the lock, since
+// appending a provider never changes existing elements,
+// and inserting, replacing or removing one copies the
+// slice.
+func (r *replacer) providerList() []provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.providers
+}
+
+// MapPriority is like Map, but mapFunc is consulted before
+// all providers with a lower priority, and after those with
+// the same or a higher one. Providers added with Map, as well
+// as static values and the default replacements, have a
+// priority of 0, so a provider with a positive priority can
+// override them even when it is added later, e.g. for values
+// scoped to a request.
+func (r *replacer) MapPriority(mapFunc ReplacementFunc, priority int) {
+	r.addProvider(provider{replace: infallible(mapFunc), priority: priority})
+}
+
+// MapNamed is like Map, but names the provider so that it
+// can be removed later with RemoveMapping. If r already has
+// a provider of that name, mapFunc takes its place in the
+// order of providers instead. An empty name is like Map.
+func (r *replacer) MapNamed(name string, mapFunc ReplacementFunc) {
+	p := provider{replace: infallible(mapFunc), name: name}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.providers {
+		if name != "" && existing.name == name {
+			providers := make([]provider, len(r.providers))
+			copy(providers, r.providers)
+			providers[i] = p
+			r.providers = providers
+			return
+		}
+	}
+	r.addProviderLocked(p)
+}
+
+// RemoveMapping removes the provider added with MapNamed
+// under name, if any. The order of the remaining providers
+// is unchanged.
+func (r *replacer) RemoveMapping(name string) {
+	if name == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.providers {
+		if existing.name == name {
+			providers := make([]provider, 0, len(r.providers)-1)
+			providers = append(providers, r.providers[:i]...)
+			r.providers = append(providers, r.providers[i+1:]...)
+			return
+		}
+	}
+}
+
+// MapIf is like Map, but mapFunc is only consulted while
+// cond returns true. cond is called each time a key is
+// looked up, so the provider can be switched on and off,
+// e.g. depending on whether a tenant is selected, without
+// changing the providers of r.
+func (r *replacer) MapIf(cond func() bool, mapFunc ReplacementFunc) {
+	r.Map(func(key string) (string, bool) {
+		if !cond() {
+			return "", false
+		}
+		return mapFunc(key)
+	})
+}
+
+// MapEnumerable adds ep to the list of value providers,
+// like Map, and includes its keys in the results of Keys,
+// AsMap and Range. Resolvability names ep as the source of
+// its values by its String method if it has one, or else
+// by its type.
+func (r *replacer) MapEnumerable(ep EnumerableProvider) {
+	source := fmt.Sprintf("%T", ep)
+	if s, ok := ep.(fmt.Stringer); ok {
+		source = s.String()
+	}
+	r.addProvider(provider{replace: infallible(ep.Replace), source: source})
+	r.mu.Lock()
+	r.enumerables = append(r.enumerables, ep)
+	r.mu.Unlock()
+}
+
+// Keys returns the sorted list of keys that r can
+// enumerate: those of static values made with Set and
+// those of providers added with MapEnumerable. Keys of
+// other providers, including the default replacements,
+// are not included, since they cannot be listed. The
+// list is a snapshot; later changes to r do not affect it.
+func (r *replacer) Keys() []string {
+	r.mu.RLock()
+	seen := make(map[string]struct{}, len(r.static))
+	keys := make([]string, 0, len(r.static))
+	add := func(key string) {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	for key := range r.static {
+		add(key)
+	}
+	enumerables := r.enumerables
+	r.mu.RUnlock()
+
+	for _, e := range enumerables {
+		for _, key := range e.Keys() {
+			add(key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// AsMap returns the values of all keys returned by Keys.
+func (r *replacer) AsMap() map[string]string {
+	m := make(map[string]string)
+	r.Range(func(key, val string) bool {
+		m[key] = val
+		return true
+	})
+	return m
+}
+
+// Range calls fn for each key returned by Keys along
+// with its value, in order, until fn returns false.
+func (r *replacer) Range(fn func(key, val string) bool) {
+	for _, key := range r.Keys() {
+		val, ok := r.get(key)
+		if !ok {
+			continue
+		}
+		if !fn(key, val) {
+			return
+		}
+	}
+}
+
+// MapTyped adds a provider which returns values as their
+// native Go types. ResolveTyped returns these values as-is;
+// everywhere else they are formatted with fmt.Sprint.
+func (r *replacer) MapTyped(typedFunc TypedReplacementFunc) {
+	r.addProvider(provider{
+		replace: func(key string) (string, bool, error) {
+			val, ok := typedFunc(key)
+			if !ok {
+				return "", false, nil
+			}
+			return fmt.Sprint(val), true, nil
+		},
+		typed: typedFunc,
+	})
+}
+
+// ResolveTyped returns the value of key from the first
+// provider that recognizes it, looked up like GetString
+// does. Values of providers added with MapTyped keep their
+// type; all other values are strings.
+func (r *replacer) ResolveTyped(key string) (interface{}, bool) {
+	key = r.foldKey(key)
+	if newKey, ok := r.deprecation(key); ok {
+		r.warnDeprecated(key, newKey)
+		key = newKey
+	}
+	var typed interface{}
+	if _, ok, err := r.lookupKey(r.providerList(), key, true, &lookupScope{typed: &typed}); !ok || err != nil {
+		return nil, false
+	}
+	return typed, true
+}
+
+// Set sets a custom variable to a static value.
+func (r *replacer) Set(variable, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.static == nil {
+		return
+	}
+	r.static[r.foldKey(variable)] = value
+	if r.lazyStatic {
+		r.lazyStatic = false
+		idx := 0
+		for idx < len(r.providers) && r.providers[idx].priority > 0 {
+			idx++
+		}
+		r.insertProvider(idx, r.staticProvider())
+	}
+}
+
+// staticProvider returns the provider of the
+// static values of r.
+func (r *replacer) staticProvider() provider {
+	return provider{
+		replace: infallible(r.fromStatic),
+		source:  SourceStatic,
+		rebind:  func(r *replacer) ReplacementErrFunc { return infallible(r.fromStatic) },
+	}
+}
+
+// Delete removes a variable with a static value
+// that was created using Set.
+func (r *replacer) Delete(variable string) {
+	r.mu.Lock()
+	delete(r.static, r.foldKey(variable))
+	r.mu.Unlock()
+}
+
+// Clone returns a copy of r which starts out with the same
+// static values, literals, providers and options, but which
+// can be changed without affecting r, and vice versa. The
+// providers themselves are shared.
+func (r *replacer) Clone() Replacer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	clone := &replacer{
+		enumerables:      append([]Enumerable(nil), r.enumerables...),
+		static:           copyStringMap(r.static),
+		lazyStatic:       r.lazyStatic,
+		cmdRunner:        r.cmdRunner,
+		cmdAllowed:       r.cmdAllowed,
+		interner:         r.interner,
+		metrics:          r.metrics,
+		literals:         copyStringMap(r.literals),
+		literalSeq:       r.literalSeq,
+		deprecated:       copyStringMap(r.deprecated),
+		opener:           r.opener,
+		closer:           r.closer,
+		expandDepth:      r.expandDepth,
+		foldKeys:         r.foldKeys,
+		slowThreshold:    r.slowThreshold,
+		slowLogger:       r.slowLogger,
+		unknownMarker:    r.unknownMarker,
+		unknownTransform: r.unknownTransform,
+		unresolved:       r.unresolved,
+	}
+	if r.history != nil {
+		clone.history = r.history.clone()
+	}
+
+	// providers that read the state of r must
+	// read that of the clone instead
+	clone.providers = make([]provider, len(r.providers))
+	for i, p := range r.providers {
+		if p.rebind != nil {
+			p.replace = p.rebind(clone)
+		}
+		clone.providers[i] = p
+	}
+	return clone
+}
+
+// copyStringMap returns a copy of m, or nil if m is nil.
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// CollapseUnknown makes r render unresolved placeholders
+// as marker instead of the empty value, with any number of
+// adjacent unresolved placeholders sharing a single marker.
+// This keeps output of incomplete configuration readable.
+// An empty marker restores the default behavior.
+func (r *replacer) CollapseUnknown(marker string) {
+	r.unknownMarker = marker
+}
+
+// TransformUnknown makes r render each unresolved placeholder
+// as the result of fn, which is given the placeholder without
+// its braces. This is useful when the output is a template for
+// another system with its own placeholders, e.g. to re-emit
+// {key} as {{key}}. It takes precedence over CollapseUnknown.
+// A nil fn restores the default behavior.
+func (r *replacer) TransformUnknown(fn func(placeholder string) string) {
+	r.unknownTransform = fn
+}
+
+// EnableRecursion makes r expand placeholders in the values
+// of placeholders, e.g. {url} whose value is
+// https://{host}/, up to maxDepth levels deep. If maxDepth
+// is 0 or less, DefaultExpandDepth is used. A placeholder
+// that depends on itself, like {a} with value {b} where {b}
+// has value {a}, is left unexpanded where the cycle closes,
+// and values beyond maxDepth are used as-is, so expansion
+// always ends. Values of literals are never expanded.
+//
+// Recursion is off by default because values may contain
+// braces that are not placeholders, such as JSON.
+func (r *replacer) EnableRecursion(maxDepth int) {
+	if maxDepth <= 0 {
+		maxDepth = DefaultExpandDepth
+	}
+	r.expandDepth = maxDepth
+}
+
+// DefaultExpandDepth is how many levels deep EnableRecursion
+// expands placeholders in values if no depth is given.
+const DefaultExpandDepth = 5
+
+// UnresolvedChan returns a channel which receives every
+// placeholder that r fails to resolve from then on, across
+// all renders, for monitoring missing configuration. The
+// channel is buffered; when it is full, placeholders are
+// dropped rather than blocking replacement.
+func (r *replacer) UnresolvedChan() <-chan string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.unresolved == nil {
+		r.unresolved = make(chan string, unresolvedChanSize)
+	}
+	return r.unresolved
+}
+
+// unresolvedChan returns the channel made by
+// UnresolvedChan, or nil if there is none yet.
+func (r *replacer) unresolvedChan() chan string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.unresolved
+}
+
+// fromStatic provides values from r.static.
+func (r *replacer) fromStatic(key string) (val string, ok bool) {
+	r.mu.RLock()
+	val, ok = r.static[key]
+	r.mu.RUnlock()
+	return
+}
+
+// get returns the value for key from the first
+// provider that recognizes it. A key whose provider
+// fails is not recognized.
+func (r *replacer) get(key string) (string, bool) {
+	val, ok, _ := r.lookup(key)
+	return val, ok
+}
+
+// lookup is like get, but it returns the error of
+// a provider that fails.
+func (r *replacer) lookup(key string) (string, bool, error) {
+	return r.lookupIn(nil, key)
+}
+
+// lookupIn is like lookup, but values in overlay
+// take precedence over those of all providers.
+func (r *replacer) lookupIn(overlay map[string]string, key string) (string, bool, error) {
+	return r.lookupAt(overlay, key, true)
+}
+
+// lookupAt is like lookupIn, but if final is false, a miss
+// is not reported to r.metrics: the lookup is followed by
+// another if key is not recognized, e.g. of a whole
+// placeholder before its key without the modifiers.
+func (r *replacer) lookupAt(overlay map[string]string, key string, final bool) (string, bool, error) {
+	if val, ok := overlay[key]; ok {
+		return val, true, nil
+	}
+	key = r.foldKey(key)
+	if newKey, ok := r.deprecation(key); ok {
+		r.warnDeprecated(key, newKey)
+		key = newKey
+	}
+	return r.lookupKey(r.providerList(), key, final, nil)
+}
+
+// lookupKey looks up key, which has already been folded and
+// mapped from a deprecated name, from providers, reporting
+// the lookup to r.metrics and recording the value in
+// r.history. If scope is not nil, it changes how the
+// providers are consulted.
+func (r *replacer) lookupKey(providers []provider, key string, final bool, scope *lookupScope) (string, bool, error) {
+	var (
+		val string
+		ok  bool
+		err error
+	)
+	if r.metrics != nil {
+		val, ok, err = r.lookupObserved(providers, key, final, scope)
+	} else {
+		val, ok, err = r.lookupProviders(providers, key, scope)
+	}
+	if ok && r.history != nil {
+		r.history.record(key, val)
+	}
+	return val, ok, err
+}
+
+// lookupProviders looks up key from providers,
+// in order, within scope like lookupKey does.
+func (r *replacer) lookupProviders(providers []provider, key string, scope *lookupScope) (string, bool, error) {
+	for _, p := range providers {
+		if val, ok, err := r.callProvider(p, key, scope); err != nil || ok {
+			return val, ok && err == nil, err
+		}
+	}
+	return "", false, nil
+}
+
+// Get returns the value of key from the first provider
+// that recognizes it, with static values made with Set
+// first, like ResolveTyped does. key is used as-is: it is
+// not parsed for modifiers or defaults, and braces in the
+// value are not interpreted.
+func (r *replacer) Get(key string) (interface{}, bool) {
+	return r.ResolveTyped(key)
+}
+
+// GetString is like Get, but values of providers
+// added with MapTyped are formatted as strings.
+func (r *replacer) GetString(key string) (string, bool) {
+	return r.get(key)
+}
+
+// GetMany looks up each of keys and returns the values of
+// the keys that were found, along with the keys that were
+// not, in the order given. Keys are not parsed for
+// modifiers or defaults. The keys are mapped from deprecated
+// names and looked up in the static values under a single
+// lock, and the other providers, as they were then, are
+// consulted afterwards, so the result is consistent even if
+// r is changed at the same time.
+func (r *replacer) GetMany(keys ...string) (map[string]string, []string) {
+	folded := make([]string, len(keys))
+	static := make(map[string]string)
+	r.mu.RLock()
+	providers := r.providers
+	for i, key := range keys {
+		folded[i] = r.foldKey(key)
+		if newKey, ok := r.deprecated[folded[i]]; ok {
+			r.warnDeprecated(folded[i], newKey)
+			folded[i] = newKey
+		}
+		if val, ok := r.static[folded[i]]; ok {
+			static[folded[i]] = val
+		}
+	}
+	r.mu.RUnlock()
+
+	values := make(map[string]string, len(keys))
+	var missing []string
+	scope := &lookupScope{static: static}
+	for i, key := range keys {
+		if val, ok, _ := r.lookupKey(providers, folded[i], true, scope); ok {
+			values[key] = val
+		} else {
+			missing = append(missing, key)
+		}
+	}
+	return values, missing
+}
+
+// AsExpandFunc returns a mapping function for os.Expand
+// which resolves names like placeholders, so that templates
+// written as ${env.HOME} or ${system.hostname|escape} can be
+// expanded. Names that cannot be resolved expand to empty
+// string.
+func (r *replacer) AsExpandFunc() func(string) string {
+	return func(name string) string {
+		val, _, _ := r.resolve(name)
+		return val
+	}
+}
+
+// FuncMap returns functions for text/template (or, after
+// conversion, html/template) which bring placeholders into
+// templates: {{repl "env.HOME"}} resolves a key like a
+// placeholder, modifiers included, and is empty if the key
+// cannot be resolved.
+func (r *replacer) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"repl": func(key string) string {
+			val, _, _ := r.resolve(key)
+			return val
+		},
+	}
+}
+
+// ReplaceAll efficiently replaces placeholders in input
+// with their values. Placeholders that are not recognized
+// by any provider, as well as values that are empty, are
+// substituted with empty. A '{' without a matching '}'
+// is left as-is. Outside of placeholders, \{ and \} are
+// literal braces and \\ is a literal backslash. If a
+// modifier fails, the value it was given is used unchanged.
+func (r *replacer) ReplaceAll(input, empty string) string {
+	out, _ := r.replace(input, empty, replaceOpts{})
+	return out
+}
+
+// ReplaceAllErr is like ReplaceAll, except that it
+// returns the first error produced by a modifier.
+func (r *replacer) ReplaceAllErr(input, empty string) (string, error) {
+	return r.replace(input, empty, replaceOpts{})
+}
+
+// ReplaceAllTo is like ReplaceAll, but it appends the result
+// to buf instead of returning it. Callers on hot paths can
+// reuse buf across renders to avoid allocating a string for
+// each result.
+func (r *replacer) ReplaceAllTo(buf *bytes.Buffer, input, empty string) {
+	if !r.mayHavePlaceholders(input) {
+		buf.WriteString(input)
+		return
+	}
+	r.replaceTo(buf, input, empty, replaceOpts{})
+}
+
+// ReplaceAllWith is like ReplaceAll, but the values in overlay
+// take precedence over those of all providers, for this render
+// only. This gives a template a few extra or overridden values
+// without changing r.
+func (r *replacer) ReplaceAllWith(input, empty string, overlay map[string]string) string {
+	out, _ := r.replace(input, empty, replaceOpts{overlay: overlay})
+	return out
+}
+
+// ReplaceOrErr is like ReplaceAllErr with an empty value of
+// empty string, but it can also fail on placeholders that are
+// usually substituted silently. If errOnUnknown is true, a
+// placeholder that no provider recognizes is an error; if
+// errOnEmpty is true, so is one whose value is empty. Errors
+// name the placeholder, its key and its byte offset in input.
+// The first error is returned along with the output.
+func (r *replacer) ReplaceOrErr(input string, errOnEmpty, errOnUnknown bool) (string, error) {
+	return r.replace(input, "", replaceOpts{errOnEmpty: errOnEmpty, errOnUnknown: errOnUnknown})
+}
+
+// ReplaceKnown is like ReplaceAll, except that placeholders
+// which are not recognized by any provider are left exactly
+// as they are in input, braces included, and so are escaped
+// braces. This allows a template to be expanded in stages by
+// different replacers, each leaving the placeholders meant
+// for the next alone. The default of a placeholder with an
+// unknown key, e.g. {later:x}, is left for the last stage
+// too, and so are modifiers for absent keys.
+func (r *replacer) ReplaceKnown(input, empty string) string {
+	out, _ := r.replace(input, empty, replaceOpts{keepUnknown: true, keepEscapes: true, keepFallbacks: true})
+	return out
+}
+
+// ReplaceAllSimple is a shorthand for the common case of
+// ReplaceAll with an empty value of empty string, except that
+// placeholders which are not recognized by any provider are
+// left as they are in input, braces included, rather than
+// removed. Unlike with ReplaceKnown, escaped braces are
+// unescaped.
+func (r *replacer) ReplaceAllSimple(input string) string {
+	out, _ := r.replace(input, "", replaceOpts{keepUnknown: true})
+	return out
+}
+
+// ReplaceRange is like ReplaceAll, but only placeholders that
+// lie entirely within the byte range [start, end) of input are
+// replaced, so that a changed region of a large document can
+// be rendered again. Everything outside of the range, including
+// placeholders that straddle its bounds and escaped braces, is
+// left exactly as it is.
+func (r *replacer) ReplaceRange(input string, start, end int, empty string) string {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(input) {
+		end = len(input)
+	}
+	if start >= end {
+		return input
+	}
+	out, _ := r.replace(input, empty, replaceOpts{ranged: true, rangeStart: start, rangeEnd: end})
+	return out
+}
+
+// ReplaceBestEffort replaces the placeholders of input that
+// can be resolved and leaves the others, including those for
+// which a provider or modifier fails, exactly as they are in
+// input. It returns every error encountered, so a caller can
+// use the partial output while still reporting the failures.
+// Empty values are substituted with empty string.
+func (r *replacer) ReplaceBestEffort(input string) (string, []error) {
+	var errs []error
+	out, _ := r.replace(input, "", replaceOpts{
+		keepUnknown: true,
+		keepFailed:  true,
+		failed: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+	return out, errs
+}
+
+// ReplaceAllContext is like ReplaceAllErr, but it stops
+// resolving placeholders once ctx is done. It then returns
+// what has been rendered so far, followed by the rest of
+// input verbatim, along with ctx.Err(). The context is
+// checked before each placeholder; providers are not
+// interrupted.
+func (r *replacer) ReplaceAllContext(ctx context.Context, input, empty string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return input, err
+	}
+	return r.replace(input, empty, replaceOpts{ctx: ctx})
+}
+
+// replaceOpts changes how placeholders are replaced.
+type replaceOpts struct {
+	// escape, if set, is applied to each resolved value
+	escape func(string) string
+
+	// unknown, if set, is called with each placeholder
+	// that could not be resolved
+	unknown func(placeholder string)
+
+	// memo, if set, remembers the results of resolving
+	// placeholders so that each is only resolved once
+	memo map[string]resolution
+
+	// ctx, if set, stops replacement once it is done
+	ctx context.Context
+
+	// keepUnknown leaves placeholders that cannot be
+	// resolved as they are in the input
+	keepUnknown bool
+
+	// keepFailed leaves placeholders that fail to
+	// resolve as they are in the input
+	keepFailed bool
+
+	// failed, if set, is called with every error
+	failed func(error)
+
+	// overlay, if set, has values that take precedence
+	// over those of all providers
+	overlay map[string]string
+
+	// chain holds the placeholders whose values are
+	// being expanded, outermost first
+	chain []string
+
+	// keepEscapes leaves escaped braces and backslashes
+	// escaped, for another replacer to handle
+	keepEscapes bool
+
+	// keepFallbacks leaves placeholders with unknown keys
+	// as they are even if they have defaults, for another
+	// replacer which may know the keys
+	keepFallbacks bool
+
+	// errOnUnknown and errOnEmpty make placeholders that
+	// cannot be resolved, or whose values are empty, errors
+	errOnUnknown, errOnEmpty bool
+
+	// ranged limits replacement to placeholders and
+	// escapes within [rangeStart, rangeEnd) of the input
+	ranged               bool
+	rangeStart, rangeEnd int
+
+	// copyKeys copies each placeholder out of the input
+	// before it is resolved, since providers and callbacks
+	// may keep it but the input may not be a real string
+	copyKeys bool
+}
+
+// inRange returns whether the part [start, next) of
+// the input may be replaced.
+func (opts *replaceOpts) inRange(start, next int) bool {
+	return !opts.ranged || (start >= opts.rangeStart && next <= opts.rangeEnd)
+}
+
+// resolution is the result of resolving a placeholder.
+type resolution struct {
+	val string
+	ok  bool
+	err error
+}
+
+// replace implements the ReplaceAll family of methods.
+func (r *replacer) replace(input, empty string, opts replaceOpts) (string, error) {
+	if !r.mayHavePlaceholders(input) {
+		return input, nil
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+
+	// it is reasonable to assume that the output
+	// will be approximately as long as the input
+	buf.Grow(len(input))
+
+	err := r.replaceTo(buf, input, empty, opts)
+	if r.interner != nil {
+		return r.interner.intern(buf.Bytes()), err
+	}
+	return buf.String(), err
+}
+
+// replaceTo writes input to buf with its placeholders
+// replaced and returns the first error encountered.
+func (r *replacer) replaceTo(buf *bytes.Buffer, input, empty string, opts replaceOpts) error {
+	var firstErr error
+	opener, closer := r.delims()
+
+	// iterate the input to find each placeholder
+	var lastWriteCursor int
+	lastUnknownEnd := -1
+	unterminated := false
+	for i := 0; i < len(input); i++ {
+		// a backslash escapes a delimiter or another
+		// backslash, which is then written as-is
+		if input[i] == escapeChar[0] {
+			if n := escapedLen(input[i+1:], opener, closer); n > 0 {
+				if !opts.keepEscapes && opts.inRange(i, i+1+n) {
+					buf.WriteString(input[lastWriteCursor:i])
+					lastWriteCursor = i + 1
+				}
+				i += n
+				continue
+			}
+		}
+		if unterminated || !strings.HasPrefix(input[i:], opener) {
+			continue
+		}
+
+		// find the end of the placeholder; if there is
+		// none, the rest of the input has no more
+		// placeholders and is written as-is
+		end := strings.Index(input[i+len(opener):], closer)
+		if end < 0 {
+			unterminated = true
+			continue
+		}
+		end += i + len(opener)
+		next := end + len(closer)
+
+		// placeholders out of range are left as they are
+		if !opts.inRange(i, next) {
+			i = next - 1
+			continue
+		}
+
+		// give up on the remaining placeholders if the
+		// context is done; they are written verbatim
+		if opts.ctx != nil && opts.ctx.Err() != nil {
+			firstErr = opts.ctx.Err()
+			break
+		}
+
+		// write the substring from the last cursor to this point
+		buf.WriteString(input[lastWriteCursor:i])
+
+		// trim the delimiters and look up the value
+		placeholder := input[i+len(opener) : end]
+		if opts.copyKeys {
+			placeholder = copyString(placeholder)
+		}
+
+		// a placeholder whose value is being expanded
+		// is part of a cycle; it is left unexpanded
+		if inChain(opts.chain, placeholder) {
+			buf.WriteString(input[i:next])
+			i, lastWriteCursor = next-1, next
+			continue
+		}
+
+		res, memoized := opts.memo[placeholder]
+		if !memoized {
+			res.val, res.ok, res.err = r.resolveIn(opts.overlay, placeholder, opts.keepFallbacks)
+			if opts.memo != nil {
+				opts.memo[placeholder] = res
+			}
+		}
+		val, err := res.val, res.err
+		switch {
+		case err != nil:
+		case !res.ok && opts.errOnUnknown:
+			err = fmt.Errorf("%s at offset %d: unknown key '%s'", input[i:next], i, placeholderKey(placeholder))
+		case res.ok && val == "" && opts.errOnEmpty:
+			err = fmt.Errorf("%s at offset %d: empty value", input[i:next], i)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if opts.failed != nil {
+				opts.failed(err)
+			}
+		}
+		if !res.ok {
+			if opts.unknown != nil {
+				opts.unknown(placeholder)
+			}
+			if unresolved := r.unresolvedChan(); unresolved != nil {
+				select {
+				case unresolved <- placeholder:
+				default: // never block rendering
+				}
+			}
+		}
+		switch {
+		case !res.ok && opts.keepUnknown, err != nil && opts.keepFailed:
+			buf.WriteString(input[i:next])
+		case !res.ok && r.unknownTransform != nil:
+			buf.WriteString(r.unknownTransform(placeholder))
+		case !res.ok && r.unknownMarker != "":
+			// a run of unresolved placeholders gets one marker
+			if i != lastUnknownEnd {
+				buf.WriteString(r.unknownMarker)
+			}
+			lastUnknownEnd = next
+		case val != "":
+			if r.expandDepth > 0 && strings.Contains(val, opener) {
+				if _, literal := r.literal(placeholder); !literal {
+					var expandErr error
+					val, expandErr = r.expandValue(val, empty, opts, placeholder)
+					if expandErr != nil && firstErr == nil {
+						firstErr = expandErr
+					}
+				}
+			}
+			if opts.escape != nil {
+				val = opts.escape(val)
+			}
+			buf.WriteString(val)
+		default:
+			buf.WriteString(empty)
+		}
+
+		// advance cursor to end of placeholder
+		i, lastWriteCursor = next-1, next
+	}
+
+	// flush any unwritten remainder
+	buf.WriteString(input[lastWriteCursor:])
+
+	return firstErr
+}
+
+// delims returns the delimiters of placeholders
+// for r, which are braces unless they are set.
+func (r *replacer) delims() (opener, closer string) {
+	if r.opener == "" {
+		return phOpen, phClose
+	}
+	return r.opener, r.closer
+}
+
+// mayHavePlaceholders reports whether input has
+// anything to replace, as a fast path.
+func (r *replacer) mayHavePlaceholders(input string) bool {
+	opener, _ := r.delims()
+	return strings.Contains(input, opener) || strings.Contains(input, escapeChar)
+}
+
+// wrap returns placeholder with the delimiters of r.
+func (r *replacer) wrap(placeholder string) string {
+	opener, closer := r.delims()
+	return opener + placeholder + closer
+}
+
+// expandValue replaces the placeholders in val, the value of
+// placeholder, for recursive expansion. Once r.expandDepth
+// values are being expanded, val is returned as-is.
+func (r *replacer) expandValue(val, empty string, opts replaceOpts, placeholder string) (string, error) {
+	if len(opts.chain) >= r.expandDepth {
+		return val, nil
+	}
+	// the outermost value is escaped as a whole, and
+	// its range does not apply to what is inside it
+	opts.escape = nil
+	opts.ranged = false
+	opts.chain = append(opts.chain[:len(opts.chain):len(opts.chain)], placeholder)
+
+	var buf bytes.Buffer
+	buf.Grow(len(val))
+	err := r.replaceTo(&buf, val, empty, opts)
+	return buf.String(), err
+}
+
+// inChain reports whether placeholder is in chain.
+func inChain(chain []string, placeholder string) bool {
+	for _, p := range chain {
+		if p == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the value of a placeholder, which is a key
+// optionally followed by modifiers, e.g. {key|mod1|mod2 arg}.
+// The key may end with a default, e.g. {key:fallback|mod}.
+// A provider that recognizes the whole placeholder always
+// wins, so keys may contain '|' and ':' themselves. If a modifier
+// fails, the value is passed on unchanged and the error is
+// returned after the remaining modifiers have run.
+func (r *replacer) resolve(placeholder string) (string, bool, error) {
+	return r.resolveIn(nil, placeholder, false)
+}
+
+// resolveIn is like resolve, but values in overlay take
+// precedence over those of all providers. If keepFallbacks
+// is true, a placeholder whose key is unknown is not
+// resolved even if it has a default or a modifier for
+// absent keys, so that a later stage, which may know the
+// key, gets to resolve it.
+func (r *replacer) resolveIn(overlay map[string]string, placeholder string, keepFallbacks bool) (string, bool, error) {
+	if val, ok := r.literal(placeholder); ok {
+		return val, true, nil
+	}
+	// only the last lookup of a key is reported as a miss
+	key := placeholder
+	if idx := strings.Index(placeholder, modSep); idx >= 0 {
+		key = placeholder[:idx]
+	}
+	hasDefault := strings.Contains(key, defaultSep)
+	val, ok, err := r.lookupAt(overlay, placeholder, key == placeholder && !hasDefault)
+	if err != nil {
+		return "", false, fmt.Errorf("{%s}: %v", placeholder, err)
+	}
+	if ok {
+		return val, true, nil
+	}
+
+	parts := strings.Split(placeholder, modSep)
+	if len(parts) > 1 {
+		val, ok, err = r.lookupAt(overlay, parts[0], !hasDefault)
+		if err != nil {
+			return "", false, fmt.Errorf("{%s}: %v", placeholder, err)
+		}
+	}
+	if !ok {
+		val, ok, err = r.keyDefault(overlay, parts[0], !keepFallbacks)
+		if err != nil {
+			return "", false, fmt.Errorf("{%s}: %v", placeholder, err)
+		}
+	}
+	mods := parts[1:]
+	if !ok && keepFallbacks {
+		return "", false, nil
+	}
+	if !ok {
+		// only modifiers for absent keys apply to an unknown
+		// key; the first one decides the outcome
+		for i, spec := range mods {
+			name, args, parseErr := parseModifier(spec)
+			if parseErr != nil {
+				continue
+			}
+			absent, isAbsent := absentModifiers[name]
+			if !isAbsent {
+				continue
+			}
+			val, err = absent(parts[0], args)
+			if err != nil {
+				return "", false, fmt.Errorf("{%s}: %v", placeholder, err)
+			}
+			mods, ok = mods[i+1:], true
+			break
+		}
+		if !ok {
+			return "", false, nil
+		}
+	}
+
+	var firstErr error
+	for _, spec := range mods {
+		modified, err := applyModifier(val, spec)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("{%s}: %v", placeholder, err)
+			}
+			continue
+		}
+		val = modified
+	}
+
+	return val, true, firstErr
+}
+
+// keyDefault resolves a key of the form key:fallback, e.g.
+// {env.PORT:8080}, to the value of the key before the first
+// colon if it is known, or else to fallback if useFallback
+// is true. A fallback of the form $(command) is a command
+// default instead, and env.NAME:type a typed environment
+// variable. It returns false if key has no default, or if
+// the fallback is not used.
+func (r *replacer) keyDefault(overlay map[string]string, key string, useFallback bool) (string, bool, error) {
+	idx := strings.Index(key, defaultSep)
+	if idx < 0 {
+		return "", false, nil
+	}
+	if isTypedEnv(key, idx) {
+		return r.typedEnv(overlay, key, idx)
+	}
+	if fallback := key[idx:]; strings.HasPrefix(fallback, cmdOpen) && strings.HasSuffix(fallback, cmdClose) {
+		return r.commandDefault(overlay, key, useFallback)
+	}
+	if val, ok, _ := r.lookupIn(overlay, key[:idx]); ok || !useFallback {
+		return val, ok, nil
+	}
+	return key[idx+len(defaultSep):], true, nil
+}
+
+// ReplacementFunc is a function that returns a replacement
+// for the given key along with true if the function is able
+// to service that key (even if the value is blank). If the
+// function does not recognize the key, false should be
+// returned.
+type ReplacementFunc func(key string) (val string, ok bool)
+
+// Resolver resolves keys to values. Resolve must
+// behave like a ReplacementFunc.
+type Resolver interface {
+	Resolve(key string) (val string, ok bool)
+}
+
+// ReplacementErrFunc is like ReplacementFunc, except that
+// it can fail, e.g. when the backend that holds the value
+// is unavailable. If err is not nil, val and ok are ignored.
+type ReplacementErrFunc func(key string) (val string, ok bool, err error)
+
+// TypedReplacementFunc is like ReplacementFunc, except that
+// it returns values as their native Go types, such as int64
+// or bool, which is convenient for template engines.
+type TypedReplacementFunc func(key string) (val interface{}, ok bool)
+
+// Enumerable is implemented by values that know
+// the set of keys they are able to resolve.
+type Enumerable interface {
+	Keys() []string
+}
+
+// EnumerableProvider is a provider that can also list
+// its keys. Replace must behave like a ReplacementFunc.
+type EnumerableProvider interface {
+	Enumerable
+	Replace(key string) (string, bool)
+}
+
+// ComposeProviders returns a ReplacementFunc which consults
+// each of providers in order and returns the value of the
+// first one that recognizes the key.
+func ComposeProviders(providers ...ReplacementFunc) ReplacementFunc {
+	return func(key string) (string, bool) {
+		for _, provider := range providers {
+			if val, ok := provider(key); ok {
+				return val, true
+			}
+		}
+		return "", false
+	}
+}
+
+// ProviderLayer is a named layer of configuration, such
+// as "default", "file", "env" or "flag".
+type ProviderLayer struct {
+	Name     string
+	Provider ReplacementFunc
+}
+
+// LayeredProvider composes several layers like
+// ComposeProviders does, but also records which
+// layer supplied the value of each key it resolved.
+// This makes it possible to find out, after
+// replacement, where a value came from.
+type LayeredProvider struct {
+	layers  []ProviderLayer
+	winners map[string]string
+	mu      sync.Mutex
+}
+
+// NewLayeredProvider returns a LayeredProvider for layers.
+// Layers are consulted in the order given, so the layer
+// with the highest precedence (e.g. "flag") should be first
+// and the one with the lowest (e.g. "default") last.
+func NewLayeredProvider(layers ...ProviderLayer) *LayeredProvider {
+	return &LayeredProvider{
+		layers:  layers,
+		winners: make(map[string]string),
+	}
+}
+
+// Replace is a ReplacementFunc that resolves key from the
+// first layer that recognizes it. Pass it to Map.
+func (lp *LayeredProvider) Replace(key string) (string, bool) {
+	for _, layer := range lp.layers {
+		if val, ok := layer.Provider(key); ok {
+			lp.mu.Lock()
+			lp.winners[key] = layer.Name
+			lp.mu.Unlock()
+			return val, true
+		}
+	}
+	return "", false
+}
+
+// LayerOf returns the name of the layer that supplied the
+// value of key the last time it was resolved, or empty
+// string if key has not been resolved by lp.
+func (lp *LayeredProvider) LayerOf(key string) string {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	return lp.winners[key]
+}
+
+// defaultReplacements is the provider of the default
+// replacements: random values, which can fail,
+// globalDefaultReplacements, and then those
+// registered with RegisterGlobalReplacement.
+func defaultReplacements(key string) (string, bool, error) {
+	if val, ok, err := randReplacement(key); ok || err != nil {
+		return val, ok, err
+	}
+	if val, ok := globalDefaultReplacements(key); ok {
+		return val, true, nil
+	}
+	globalReplacementsMu.RLock()
+	registered := globalReplacements
+	globalReplacementsMu.RUnlock()
+	for _, g := range registered {
+		if val, ok := g.replace(key); ok {
+			return val, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// RegisterGlobalReplacement adds mapFunc to the default
+// replacements of all replacers made with NewReplacer, even
+// those made before, so that plugins can contribute values,
+// such as {build.version}. It is usually called from init.
+// Registered providers are consulted after the built-in
+// default replacements (env.*, system.* and so on), in the
+// order they were registered, and so before providers added
+// with Map.
+func RegisterGlobalReplacement(mapFunc ReplacementFunc) {
+	globalReplacementsMu.Lock()
+	globalReplacements = append(globalReplacements, globalReplacement{replace: mapFunc})
+	globalReplacementsMu.Unlock()
+}
+
+// RegisterNamedGlobalReplacement is like RegisterGlobalReplacement,
+// but it names the provider, e.g. after the plugin that registers
+// it. It fails if name is empty or already registered.
+func RegisterNamedGlobalReplacement(name string, mapFunc ReplacementFunc) error {
+	if name == "" {
+		return fmt.Errorf("global replacement must have a name")
+	}
+	globalReplacementsMu.Lock()
+	defer globalReplacementsMu.Unlock()
+	for _, g := range globalReplacements {
+		if g.name == name {
+			return fmt.Errorf("global replacement '%s' is already registered", name)
+		}
+	}
+	globalReplacements = append(globalReplacements, globalReplacement{name: name, replace: mapFunc})
+	return nil
+}
+
+// globalReplacement is a provider
+// registered for all replacers.
+type globalReplacement struct {
+	name    string
+	replace ReplacementFunc
+}
+
+// globalReplacements are the registered global
+// replacements; globalReplacementsMu guards it.
+var (
+	globalReplacements   []globalReplacement
+	globalReplacementsMu sync.RWMutex
+)
+
+// globalDefaultReplacements provides replacements
+// that are available to every replacer made with
+// NewReplacer.
+func globalDefaultReplacements(key string) (string, bool) {
+	// check environment variable; unset variables are
+	// not recognized, so that defaults can apply
+	if strings.HasPrefix(key, envPrefix) {
+		return envReplacement(key[len(envPrefix):])
+	}
+	const envJSONPrefix = "envjson."
+	if strings.HasPrefix(key, envJSONPrefix) {
+		return envJSONReplacement(key[len(envJSONPrefix):])
+	}
+	const sdCredsPrefix = "sdcreds."
+	if strings.HasPrefix(key, sdCredsPrefix) {
+		return systemdCredential(key[len(sdCredsPrefix):])
+	}
+	const filePrefix = "file."
+	if strings.HasPrefix(key, filePrefix) {
+		return fileReplacement(key[len(filePrefix):])
+	}
+	const buildPrefix = "build."
+	if strings.HasPrefix(key, buildPrefix) {
+		return buildReplacement(key[len(buildPrefix):])
+	}
+	const pathRelPrefix = "path.rel "
+	if strings.HasPrefix(key, pathRelPrefix) {
+		return relativePath(key[len(pathRelPrefix):])
+	}
+	const timePrefix = "time.now"
+	if strings.HasPrefix(key, timePrefix) {
+		return timeReplacement(key[len(timePrefix):])
+	}
+
+	switch key {
+	case "system.hostname", "system.hostname.short", "system.hostname.fqdn":
+		// OK if there is an error; just return empty string
+		name, _ := hostname()
+		return hostnameForm(key, name), true
+	case "system.slash":
+		return string(filepath.Separator), true
+	case "system.os":
+		return runtime.GOOS, true
+	case "system.arch":
+		return runtime.GOARCH, true
+	case "system.wd":
+		wd, err := os.Getwd()
+		return wd, err == nil
+	case "system.num_cpu":
+		return strconv.Itoa(runtime.NumCPU()), true
+	case "system.pid":
+		return strconv.Itoa(os.Getpid()), true
+	case "system.ppid":
+		return strconv.Itoa(os.Getppid()), true
+	case "system.user", "system.uid", "system.gid":
+		return currentUser(key)
+	case "system.uptime":
+		return time.Since(processStart).String(), true
+	case "system.memlimit", "system.cpulimit":
+		return cgroupLimit(key)
+	case "uuid":
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return "", false
+		}
+		return id.String(), true
+	case "caddy.data_dir":
+		return dataDir(), true
+	case "caddy.config_dir":
+		return configDir(), true
+	}
+
+	return "", false
+}
+
+// hostnameForm returns the form of the hostname name
+// which is asked for by key: as-is, only the part before
+// the first dot, or fully qualified. If the fully qualified
+// name cannot be looked up, name is returned as-is.
+func hostnameForm(key, name string) string {
+	switch key {
+	case "system.hostname.short":
+		if i := strings.Index(name, "."); i >= 0 {
+			return name[:i]
+		}
+	case "system.hostname.fqdn":
+		if name == "" {
+			return name
+		}
+		cname, err := lookupCNAME(name)
+		if err != nil || cname == "" || cname == "." {
+			return name
+		}
+		return strings.TrimSuffix(cname, ".")
+	}
+	return name
+}
+
+// timeReplacement formats the current time as asked for by
+// suffix, which is what follows time.now in the key: nothing
+// for RFC 3339, .unix or .unix_ms for seconds or milliseconds
+// since the epoch, .http for the format of HTTP dates, or
+// .format: followed by a layout for time.Format, such as
+// {time.now.format:2006-01-02}.
+func timeReplacement(suffix string) (string, bool) {
+	t := now()
+	// modifiers after a layout are not part of it
+	const formatPrefix = ".format:"
+	if strings.HasPrefix(suffix, formatPrefix) && !strings.Contains(suffix, modSep) {
+		return t.Format(suffix[len(formatPrefix):]), true
+	}
+	switch suffix {
+	case "":
+		return t.Format(time.RFC3339), true
+	case ".unix":
+		return strconv.FormatInt(t.Unix(), 10), true
+	case ".unix_ms":
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10), true
+	case ".http":
+		return t.UTC().Format(httpTimeFormat), true
+	}
+	return "", false
+}
+
+// httpTimeFormat is the format of dates in HTTP
+// headers, like http.TimeFormat.
+const httpTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// now is the clock of the time.now placeholders;
+// it can be swapped in tests.
+var now = time.Now
+
+// hostname and lookupCNAME can be swapped in tests.
+var (
+	hostname    = os.Hostname
+	lookupCNAME = net.LookupCNAME
+)
+
+// currentUser returns the name, user ID or group ID
+// of the current user, depending on key. IDs are only
+// available on systems where they are decimal numbers,
+// which excludes Windows.
+func currentUser(key string) (string, bool) {
+	u, err := user.Current()
+	if err != nil {
+		return "", false
+	}
+	if key == "system.user" {
+		return u.Username, true
+	}
+	if runtime.GOOS == "windows" {
+		return "", false
+	}
+	if key == "system.uid" {
+		return u.Uid, true
+	}
+	return u.Gid, true
+}
+
+// dataDir returns the directory where Caddy stores its data,
+// such as certificates, for {caddy.data_dir}: AssetsPath, which
+// follows CADDYPATH. If CADDYPATH is not set and the home
+// directory is not known, the directory is unknown and empty
+// string is returned rather than a relative path.
+func dataDir() string {
+	if os.Getenv("CADDYPATH") == "" && userHomeDir() == "" {
+		return ""
+	}
+	return AssetsPath()
+}
+
+// configDir returns the directory for the configuration of
+// Caddy for {caddy.config_dir}: a caddy folder in
+// XDG_CONFIG_HOME, or else in the conventional configuration
+// directory of the platform. Empty string is returned rather
+// than a relative path if neither is known.
+func configDir() string {
+	if base := os.Getenv("XDG_CONFIG_HOME"); base != "" {
+		return filepath.Join(base, "caddy")
+	}
+	return platformConfigDir()
+}
+
+// platformConfigDir returns the folder of Caddy in the directory
+// for configuration files of the platform, which on platforms
+// without one of their own is .config in the home directory.
+// It returns empty string if that directory is not known.
+func platformConfigDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		if appData := os.Getenv("AppData"); appData != "" {
+			return filepath.Join(appData, "Caddy")
+		}
+		return ""
+	}
+	home := userHomeDir()
+	if home == "" {
+		return ""
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "Caddy")
+	case "plan9":
+		return filepath.Join(home, "lib", "caddy")
+	}
+	return filepath.Join(home, ".config", "caddy")
+}
+
+// processStart is when the process started,
+// for the purpose of computing its uptime.
+var processStart = time.Now()
+
+// bufPool is a pool of buffers used for replacing.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// unresolvedChanSize is the buffer size of
+// the channel returned by UnresolvedChan.
+const unresolvedChanSize = 100
+
+const phOpen, phClose, modSep, defaultSep = "{", "}", "|", ":"
+
+// escapeChar makes the delimiter or backslash after
+// it literal, outside of placeholders.
+const escapeChar = "\\"
+
+// escapedLen returns the length of what escapeChar
+// makes literal at the start of s: a delimiter or
+// escapeChar itself. It is 0 if there is none.
+func escapedLen(s, opener, closer string) int {
+	for _, d := range []string{opener, closer, escapeChar} {
+		if strings.HasPrefix(s, d) {
+			return len(d)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Bake renders input once and for all, like ReplaceAll,
+// for configuration that is resolved at startup and never
+// again. Each distinct placeholder is resolved only once,
+// so providers are not consulted again for placeholders
+// which appear more than once, and all occurrences get
+// the same value.
+func (r *replacer) Bake(input, empty string) string {
+	out, _ := r.replace(input, empty, replaceOpts{
+		memo: make(map[string]resolution),
+	})
+	return out
+}
+
+// BakeStrict is like Bake, but it returns an error naming
+// every placeholder that could not be resolved, so that
+// incomplete configuration is caught at startup.
+func (r *replacer) BakeStrict(input string) (string, error) {
+	var unknown []string
+	seen := make(map[string]struct{})
+	out, err := r.replace(input, "", replaceOpts{
+		memo: make(map[string]resolution),
+		unknown: func(placeholder string) {
+			if _, ok := seen[placeholder]; !ok {
+				seen[placeholder] = struct{}{}
+				unknown = append(unknown, r.wrap(placeholder))
+			}
+		},
+	})
+	if err != nil {
+		return out, err
+	}
+	if len(unknown) > 0 {
+		return out, fmt.Errorf("unresolved placeholders remain after baking: %s", strings.Join(unknown, ", "))
+	}
+	return out, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReplacerBake(t *testing.T) {
+	var calls int
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Map(func(key string) (string, bool) {
+		if key == "counter" {
+			calls++
+			return strconv.Itoa(calls), true
+		}
+		return "", false
+	})
+
+	template := "{host} {counter} {counter} {system.os}"
+	baked := rep.Bake(template, "")
+	if strings.Contains(baked, phOpen) || strings.Contains(baked, phClose) {
+		t.Errorf("Expected no placeholders to remain, got '%s'", baked)
+	}
+	if !strings.HasPrefix(baked, "example.com 1 1 ") {
+		t.Errorf("Expected each placeholder to be resolved once, got '%s'", baked)
+	}
+
+	baked, err := rep.BakeStrict(template)
+	if err != nil {
+		t.Errorf("Expected no error baking a resolvable template, got: %v", err)
+	}
+	if !strings.HasPrefix(baked, "example.com 2 2 ") {
+		t.Errorf("Expected each placeholder to be resolved once, got '%s'", baked)
+	}
+
+	baked, err = rep.BakeStrict("{host} {missing} {other|escape} {missing}")
+	if err == nil {
+		t.Fatalf("Expected error for unresolved placeholders, got none")
+	}
+	if !strings.HasSuffix(err.Error(), ": {missing}, {other|escape}") {
+		t.Errorf("Expected error to name each unresolved placeholder once, got: %v", err)
+	}
+	if baked != "example.com   " {
+		t.Errorf("Expected '%s' got '%s'", "example.com   ", baked)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// +build go1.18
+
+package caddy
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// buildInfo holds the build information embedded in
+// the binary, read once when the package is initialized.
+var buildInfo, haveBuildInfo = debug.ReadBuildInfo()
+
+// buildReplacement resolves name, a key of the build.
+// namespace without the prefix, from the embedded build
+// information: goversion, or vcs.revision, vcs.time and
+// the other version control settings. Keys are unknown if
+// the binary has no build information or no such setting.
+func buildReplacement(name string) (string, bool) {
+	if !haveBuildInfo {
+		return "", false
+	}
+	if name == "goversion" {
+		return buildInfo.GoVersion, true
+	}
+	if !strings.HasPrefix(name, "vcs.") {
+		return "", false
+	}
+	for _, setting := range buildInfo.Settings {
+		if setting.Key == name {
+			return setting.Value, true
+		}
+	}
+	return "", false
+}
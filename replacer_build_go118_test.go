@@ -0,0 +1,59 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// +build go1.18
+
+package caddy
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+func TestBuildReplacement(t *testing.T) {
+	rep := NewReplacer()
+	if actual := rep.ReplaceAll("{build.goversion}", "-"); actual != runtime.Version() {
+		t.Errorf("Expected '%s' got '%s'", runtime.Version(), actual)
+	}
+
+	// test binaries are not stamped with version control information
+	testProvider(t, globalDefaultReplacements, []providerTestCase{
+		{key: "build.vcs.nope", ok: false},
+		{key: "build.GOOS", ok: false},
+		{key: "build.", ok: false},
+	})
+
+	old, oldOK := buildInfo, haveBuildInfo
+	defer func() { buildInfo, haveBuildInfo = old, oldOK }()
+	buildInfo = &debug.BuildInfo{
+		GoVersion: "go1.99",
+		Settings: []debug.BuildSetting{
+			{Key: "GOOS", Value: "plan9"},
+			{Key: "vcs.revision", Value: "0123abcd"},
+			{Key: "vcs.time", Value: "2019-05-01T12:00:00Z"},
+		},
+	}
+	testProvider(t, globalDefaultReplacements, []providerTestCase{
+		{key: "build.goversion", expected: "go1.99", ok: true},
+		{key: "build.vcs.revision", expected: "0123abcd", ok: true},
+		{key: "build.vcs.time", expected: "2019-05-01T12:00:00Z", ok: true},
+		{key: "build.vcs.modified", ok: false},
+		{key: "build.GOOS", ok: false},
+	})
+
+	haveBuildInfo = false
+	testProvider(t, globalDefaultReplacements, []providerTestCase{
+		{key: "build.goversion", ok: false},
+	})
+}
@@ -0,0 +1,57 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import (
+	"bytes"
+	"unsafe"
+)
+
+// ReplaceAllBytes is like ReplaceAll, but for input and output
+// as byte slices, e.g. lines of a log. input is scanned where
+// it is, without copying it to a string first, and the output
+// is built in a pooled buffer, so only the result and the keys
+// of placeholders are allocated. input must not be modified
+// while ReplaceAllBytes runs. If input has no placeholders, it
+// is returned itself.
+func (r *replacer) ReplaceAllBytes(input []byte, empty string) []byte {
+	s := bytesView(input)
+	if !r.mayHavePlaceholders(s) {
+		return input
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+	buf.Grow(len(input))
+
+	r.replaceTo(buf, s, empty, replaceOpts{copyKeys: true})
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+// bytesView returns the contents of b as a string without
+// copying them. The string changes if b does, so it must
+// not outlive the call it is made for; substrings that do
+// must be copied.
+func bytesView(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// copyString returns a copy of s which does not share memory
+// with it, for substrings of a string made by bytesView.
+func copyString(s string) string {
+	b := make([]byte, len(s))
+	copy(b, s)
+	return bytesView(b)
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReplacerReplaceAllBytes(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Set("blank", "")
+
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "https://{host}/", expected: "https://example.com/"},
+		{input: "{host|upper}:{port:443}", expected: "EXAMPLE.COM:443"},
+		{input: "[{blank}] [{unknown}]", expected: "[-] [-]"},
+		{input: "\\{host\\}", expected: "{host}"},
+		{input: "no placeholders", expected: "no placeholders"},
+		{input: "", expected: ""},
+	} {
+		if actual := rep.ReplaceAllBytes([]byte(tc.input), "-"); !bytes.Equal(actual, []byte(tc.expected)) {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// keys kept by providers do not change with the input
+	var asked []string
+	rep.Map(func(key string) (string, bool) {
+		asked = append(asked, key)
+		return "", false
+	})
+	input := []byte("{missing}")
+	rep.ReplaceAllBytes(input, "")
+	copy(input, "{changed}")
+	if len(asked) != 1 || asked[0] != "missing" {
+		t.Errorf("Expected provider to keep key 'missing', got %v", asked)
+	}
+}
+
+func BenchmarkReplacerReplaceAllBytes(b *testing.B) {
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Set("status", "200")
+	line := []byte("127.0.0.1 - [14/Sep/2018:17:03:07 +0200] \"GET / HTTP/1.1\" {status} 1234 \"https://{host}/\" \"Mozilla/5.0 (X11; Linux x86_64)\"")
+
+	b.Run("ReplaceAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = []byte(rep.ReplaceAll(string(line), ""))
+		}
+	})
+	b.Run("ReplaceAllBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rep.ReplaceAllBytes(line, "")
+		}
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import "strings"
+
+// EnableCaseInsensitiveKeys makes the keys of r case-insensitive:
+// keys are lowercased when values are made with Set or deleted
+// and when they are looked up, so {Env.Home} and {SITE} find
+// the values of env.home and site. Providers are therefore
+// asked for lowercase keys, except in the namespaces of the
+// default replacements whose names are case-sensitive outside
+// of Caddy: for env.*, envjson.*, sdcreds.*, file.* and fifo.*,
+// only the namespace is lowercased, so {ENV.HOME} still reads
+// the environment variable HOME, not home. Like the other
+// options, it must be set before r is shared or has values.
+func (r *replacer) EnableCaseInsensitiveKeys() {
+	r.foldKeys = true
+}
+
+// caseSensitiveNamespaces are the namespaces whose
+// names are kept as-is by EnableCaseInsensitiveKeys.
+var caseSensitiveNamespaces = []string{"env.", "envjson.", "sdcreds.", "file.", "fifo."}
+
+// foldKey returns the form of key that r stores and
+// looks up, which is key itself unless r has
+// case-insensitive keys.
+func (r *replacer) foldKey(key string) string {
+	if !r.foldKeys {
+		return key
+	}
+	for _, ns := range caseSensitiveNamespaces {
+		if len(key) >= len(ns) && strings.EqualFold(key[:len(ns)], ns) {
+			return ns + key[len(ns):]
+		}
+	}
+	return strings.ToLower(key)
+}
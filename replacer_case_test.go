@@ -0,0 +1,58 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReplacerCaseInsensitiveKeys(t *testing.T) {
+	os.Setenv("CADDY_REPLACER_CASE", "upper")
+	defer os.Unsetenv("CADDY_REPLACER_CASE")
+
+	const input = "{site}|{Site}|{SITE.Name}|{ENV.CADDY_REPLACER_CASE}|{Env.caddy_replacer_case}|{System.OS}"
+
+	// keys are case-sensitive by default
+	rep := NewReplacer()
+	rep.Set("site", "example.com")
+	rep.Set("Site.name", "Example")
+	if actual, expected := rep.ReplaceAll(input, "-"), "example.com|-|-|-|-|-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	rep = NewReplacer()
+	rep.EnableCaseInsensitiveKeys()
+	rep.Set("site", "example.com")
+	rep.Set("Site.name", "Example")
+	if actual, expected := rep.ReplaceAll(input, "-"), "example.com|example.com|Example|upper|-|"+rep.ReplaceAll("{system.os}", ""); actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	if val, ok := rep.GetString("SITE"); !ok || val != "example.com" {
+		t.Errorf("Expected '%s' got '%s' (ok=%t)", "example.com", val, ok)
+	}
+	if val, ok := rep.Get("site.NAME"); !ok || val != "Example" {
+		t.Errorf("Expected '%s' got '%v' (ok=%t)", "Example", val, ok)
+	}
+
+	rep.DeprecateKey("Old.Site", "SITE")
+	if actual, expected := rep.ReplaceAll("{Old.Site}|{old.site}", "-"), "example.com|example.com"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	rep.Delete("SITE")
+	if actual, expected := rep.ReplaceAll("{site}", "-"), "-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupLimit returns the memory limit in bytes
+// (system.memlimit) or the CPU limit as a number of
+// CPUs (system.cpulimit) of the cgroup, from cgroup v2
+// if it is mounted or else from cgroup v1. If there is
+// no such limit, the key is not recognized.
+func cgroupLimit(key string) (string, bool) {
+	v2 := true
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		v2 = false
+	}
+
+	if key == "system.memlimit" {
+		if v2 {
+			return readCgroupInt("memory.max")
+		}
+		limit, ok := readCgroupInt("memory/memory.limit_in_bytes")
+		// v1 reports "no limit" as a huge page-aligned number
+		if !ok || len(limit) >= 19 {
+			return "", false
+		}
+		return limit, true
+	}
+
+	var quota, period string
+	if v2 {
+		fields := strings.Fields(readCgroupFile("cpu.max"))
+		if len(fields) != 2 {
+			return "", false
+		}
+		quota, period = fields[0], fields[1]
+	} else {
+		quota = readCgroupFile("cpu/cpu.cfs_quota_us")
+		period = readCgroupFile("cpu/cpu.cfs_period_us")
+	}
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return "", false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return "", false
+	}
+	return strconv.FormatFloat(q/p, 'f', -1, 64), true
+}
+
+// readCgroupFile returns the trimmed contents of the
+// file at path relative to cgroupRoot, or empty string
+// if it cannot be read.
+func readCgroupFile(path string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(cgroupRoot, path))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(contents))
+}
+
+// readCgroupInt returns the contents of the file at path
+// relative to cgroupRoot if it is a positive integer.
+func readCgroupInt(path string) (string, bool) {
+	val := readCgroupFile(path)
+	if n, err := strconv.ParseInt(val, 10, 64); err != nil || n <= 0 {
+		return "", false
+	}
+	return val, true
+}
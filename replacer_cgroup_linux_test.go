@@ -0,0 +1,108 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeCgroupFixture creates files (path -> contents)
+// in a temporary directory and points cgroupRoot at it.
+func makeCgroupFixture(t *testing.T, files map[string]string) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "caddy_cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, contents := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldRoot := cgroupRoot
+	cgroupRoot = dir
+	return func() {
+		cgroupRoot = oldRoot
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCgroupLimit(t *testing.T) {
+	for i, tc := range []struct {
+		files    map[string]string
+		memlimit string
+		cpulimit string
+	}{
+		{
+			// cgroup v2 with limits
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory",
+				"memory.max":         "536870912\n",
+				"cpu.max":            "150000 100000\n",
+			},
+			memlimit: "536870912",
+			cpulimit: "1.5",
+		},
+		{
+			// cgroup v2 without limits
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory",
+				"memory.max":         "max\n",
+				"cpu.max":            "max 100000\n",
+			},
+		},
+		{
+			// cgroup v1 with limits
+			files: map[string]string{
+				"memory/memory.limit_in_bytes": "1073741824\n",
+				"cpu/cpu.cfs_quota_us":         "200000\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+			},
+			memlimit: "1073741824",
+			cpulimit: "2",
+		},
+		{
+			// cgroup v1 without limits
+			files: map[string]string{
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+				"cpu/cpu.cfs_quota_us":         "-1\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+			},
+		},
+		{
+			// no cgroups at all
+			files: map[string]string{},
+		},
+	} {
+		cleanup := makeCgroupFixture(t, tc.files)
+		for key, expected := range map[string]string{
+			"system.memlimit": tc.memlimit,
+			"system.cpulimit": tc.cpulimit,
+		} {
+			val, ok := globalDefaultReplacements(key)
+			if ok != (expected != "") || val != expected {
+				t.Errorf("Test %d: Expected '%s' for %s got '%s' (ok=%t)", i, expected, key, val, ok)
+			}
+		}
+		cleanup()
+	}
+}
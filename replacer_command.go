@@ -0,0 +1,87 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CommandRunner runs the command name with args and
+// returns its output.
+type CommandRunner func(name string, args ...string) (string, error)
+
+// EnableCommandDefaults allows placeholders of the form
+// {key:$(command args)}: if no provider recognizes key,
+// the command is run and its output, without trailing
+// newlines, becomes the value. Only commands whose name
+// is in allowed are ever run. If runner is nil, commands
+// are executed with os/exec.
+func (r *replacer) EnableCommandDefaults(runner CommandRunner, allowed ...string) {
+	if runner == nil {
+		runner = execCommand
+	}
+	r.cmdRunner = runner
+	r.cmdAllowed = make(map[string]struct{}, len(allowed))
+	for _, name := range allowed {
+		r.cmdAllowed[name] = struct{}{}
+	}
+}
+
+// commandDefault resolves a key of the form key:$(command).
+// The value is that of the key before the colon if overlay
+// or a provider recognizes it, whether or not command
+// defaults are enabled; only otherwise is command run, if
+// they are and run is true. It returns false if key is not
+// of that form, or if the key is unknown and command is
+// not run.
+func (r *replacer) commandDefault(overlay map[string]string, key string, run bool) (string, bool, error) {
+	if !strings.HasSuffix(key, cmdClose) {
+		return "", false, nil
+	}
+	idx := strings.Index(key, cmdOpen)
+	if idx < 0 {
+		return "", false, nil
+	}
+	if val, ok, _ := r.lookupIn(overlay, key[:idx]); ok {
+		return val, true, nil
+	}
+	if r.cmdRunner == nil || !run {
+		return "", false, nil
+	}
+
+	command := key[idx+len(cmdOpen) : len(key)-len(cmdClose)]
+	name, args, err := SplitCommandAndArgs(command)
+	if err != nil {
+		return "", false, err
+	}
+	if _, ok := r.cmdAllowed[name]; !ok {
+		return "", false, fmt.Errorf("command '%s' is not allowed", name)
+	}
+	out, err := r.cmdRunner(name, args...)
+	if err != nil {
+		return "", false, fmt.Errorf("running '%s': %v", command, err)
+	}
+	return strings.TrimRight(out, "\r\n"), true, nil
+}
+
+// execCommand is the default CommandRunner.
+func execCommand(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).Output()
+	return string(out), err
+}
+
+const cmdOpen, cmdClose = ":$(", ")"
@@ -0,0 +1,99 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReplacerCommandDefaults(t *testing.T) {
+	var ran [][]string
+	runner := func(name string, args ...string) (string, error) {
+		ran = append(ran, append([]string{name}, args...))
+		return "v1.2.3-4-gabcdef\n", nil
+	}
+
+	os.Setenv("CADDY_REPLACER_VERSION", "v2.0.0")
+	defer os.Unsetenv("CADDY_REPLACER_VERSION")
+
+	rep := NewReplacer()
+	rep.Set("static", "value")
+
+	// without opting in, nothing is run
+	if actual := rep.ReplaceAll("{unknown:$(git describe)}", "-"); actual != "-" {
+		t.Errorf("Expected '%s' got '%s'", "-", actual)
+	}
+	// but keys that are set still resolve
+	if actual := rep.ReplaceAll("{static:$(git describe)}", "-"); actual != "value" {
+		t.Errorf("Expected '%s' got '%s'", "value", actual)
+	}
+	if len(ran) != 0 {
+		t.Errorf("Expected no commands to run, got %v", ran)
+	}
+
+	rep.EnableCommandDefaults(runner, "git")
+
+	for i, tc := range []struct {
+		input     string
+		expected  string
+		shouldErr bool
+		ran       [][]string
+	}{
+		{
+			// primary key present: command not run
+			input:    "{env.CADDY_REPLACER_VERSION:$(git describe)}",
+			expected: "v2.0.0",
+		},
+		{
+			input:    "{static:$(git describe)}",
+			expected: "value",
+		},
+		{
+			// primary key absent: command run
+			input:    "{unknown:$(git describe --tags)}",
+			expected: "v1.2.3-4-gabcdef",
+			ran:      [][]string{{"git", "describe", "--tags"}},
+		},
+		{
+			// modifiers apply to the command output
+			input:    "{unknown:$(git describe)|escape}",
+			expected: "v1.2.3-4-gabcdef",
+			ran:      [][]string{{"git", "describe"}},
+		},
+		{
+			// command not in allowlist
+			input:     "{unknown:$(rm -rf /)}",
+			expected:  "",
+			shouldErr: true,
+		},
+	} {
+		ran = nil
+		actual, err := rep.ReplaceAllErr(tc.input, "")
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error, but got none", i)
+		}
+		if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: Expected no error, but got: %v", i, err)
+		}
+		if actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+		if !reflect.DeepEqual(ran, tc.ran) {
+			t.Errorf("Test %d: Expected commands %v to run, got %v", i, tc.ran, ran)
+		}
+	}
+}
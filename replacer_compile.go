@@ -0,0 +1,160 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// CompiledTemplate is a template whose placeholders have been
+// found in advance, with the default braces as delimiters, so
+// that rendering it does not scan the text between them again.
+// It can be rendered with many replacers, concurrently.
+type CompiledTemplate struct {
+	source   string
+	segments []templateSegment
+}
+
+// templateSegment is literal text, or a placeholder
+// with its delimiters if placeholder is true.
+type templateSegment struct {
+	text        string
+	placeholder bool
+}
+
+// Compile returns the compiled form of input. Templates are
+// cached by their source in a bounded cache of the templates
+// compiled most recently, so compiling the same template again,
+// e.g. whenever the config is reloaded, returns the same
+// *CompiledTemplate without parsing input again.
+func Compile(input string) *CompiledTemplate {
+	if tpl, ok := compileCache.get(input); ok {
+		return tpl
+	}
+	tpl := &CompiledTemplate{source: input}
+	var literal strings.Builder
+	lastWriteCursor := 0
+	unterminated := false
+	for i := 0; i < len(input); i++ {
+		if input[i] == escapeChar[0] {
+			if n := escapedLen(input[i+1:], phOpen, phClose); n > 0 {
+				literal.WriteString(input[lastWriteCursor:i])
+				lastWriteCursor = i + 1
+				i += n
+				continue
+			}
+		}
+		if unterminated || !strings.HasPrefix(input[i:], phOpen) {
+			continue
+		}
+		// after a '{' without a matching '}' there are no
+		// more placeholders, but escapes still apply
+		end := strings.Index(input[i+len(phOpen):], phClose)
+		if end < 0 {
+			unterminated = true
+			continue
+		}
+		next := i + len(phOpen) + end + len(phClose)
+		literal.WriteString(input[lastWriteCursor:i])
+		if literal.Len() > 0 {
+			tpl.segments = append(tpl.segments, templateSegment{text: literal.String()})
+			literal.Reset()
+		}
+		tpl.segments = append(tpl.segments, templateSegment{text: input[i:next], placeholder: true})
+		i, lastWriteCursor = next-1, next
+	}
+	literal.WriteString(input[lastWriteCursor:])
+	if literal.Len() > 0 {
+		tpl.segments = append(tpl.segments, templateSegment{text: literal.String()})
+	}
+	return compileCache.add(input, tpl)
+}
+
+// Source returns the template that tpl was compiled from.
+func (tpl *CompiledTemplate) Source() string {
+	return tpl.source
+}
+
+// Render replaces the placeholders of tpl with their values
+// from rep, like rep.ReplaceAll(tpl.Source(), empty) does for
+// a replacer made with the default delimiters, except that a
+// run of adjacent placeholders that rep cannot resolve gets
+// one marker per placeholder if rep collapses unknown ones.
+func (tpl *CompiledTemplate) Render(rep Replacer, empty string) string {
+	var sb strings.Builder
+	sb.Grow(len(tpl.source))
+	for _, seg := range tpl.segments {
+		if seg.placeholder {
+			sb.WriteString(rep.ReplaceAll(seg.text, empty))
+		} else {
+			sb.WriteString(seg.text)
+		}
+	}
+	return sb.String()
+}
+
+// compileCache holds the templates compiled most recently.
+var compileCache = newTemplateCache(256)
+
+// templateCache is a concurrency-safe cache of compiled
+// templates which evicts the least recently used
+// template once it holds max templates.
+type templateCache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List // of *CompiledTemplate, most recent first
+	entries map[string]*list.Element
+}
+
+// newTemplateCache returns a cache of at most max templates.
+func newTemplateCache(max int) *templateCache {
+	return &templateCache{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached template compiled from source.
+func (c *templateCache) get(source string) (*CompiledTemplate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[source]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*CompiledTemplate), true
+}
+
+// add caches tpl, evicting the least recently used
+// template if needed, and returns it, or the template
+// that was cached for source in the meantime.
+func (c *templateCache) add(source string, tpl *CompiledTemplate) *CompiledTemplate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[source]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*CompiledTemplate)
+	}
+	c.entries[source] = c.order.PushFront(tpl)
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*CompiledTemplate).source)
+	}
+	return tpl
+}
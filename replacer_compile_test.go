@@ -0,0 +1,86 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Set("blank", "")
+
+	for i, input := range []string{
+		"https://{host}/",
+		"{host|upper}:{port:443} [{blank}] [{unknown}]",
+		"\\{host\\} \\\\{host}",
+		"{host} {unterminated",
+		"{abc \\{",
+		"{host} {abc \\} \\\\ {host",
+		"no placeholders",
+		"",
+	} {
+		tpl := Compile(input)
+		if actual := tpl.Source(); actual != input {
+			t.Errorf("Test %d: Expected source '%s' got '%s'", i, input, actual)
+		}
+		if actual, expected := tpl.Render(rep, "-"), rep.ReplaceAll(input, "-"); actual != expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, expected, actual)
+		}
+	}
+}
+
+func TestCompileCache(t *testing.T) {
+	defer func(cache *templateCache) { compileCache = cache }(compileCache)
+	compileCache = newTemplateCache(2)
+
+	a := Compile("{a}")
+	if Compile("{a}") != a {
+		t.Error("Expected second compile to return the cached template")
+	}
+	b := Compile("{b}")
+	Compile("{a}") // a is now used more recently than b
+	Compile("{c}") // evicts b
+	if Compile("{a}") != a {
+		t.Error("Expected recently used template to stay cached")
+	}
+	if Compile("{b}") == b {
+		t.Error("Expected least recently used template to be evicted")
+	}
+	if actual := compileCache.order.Len(); actual != 2 {
+		t.Errorf("Expected %d cached templates got %d", 2, actual)
+	}
+
+	// concurrent compiles of the same template agree
+	compileCache = newTemplateCache(16)
+	var wg sync.WaitGroup
+	templates := make([]*CompiledTemplate, 8)
+	for i := range templates {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			Compile(fmt.Sprintf("{key%d}", i%2))
+			templates[i] = Compile("{shared}")
+		}(i)
+	}
+	wg.Wait()
+	for i, tpl := range templates {
+		if tpl != templates[0] {
+			t.Errorf("Test %d: Expected the same template for the same source", i)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import "log"
+
+// DeprecateKey marks oldKey as a deprecated name of newKey.
+// Looking up oldKey yields the value of newKey, and the
+// first such lookup logs a warning suggesting newKey. Both
+// keys are folded like those of Set, so that they take effect
+// with case-insensitive keys.
+func (r *replacer) DeprecateKey(oldKey, newKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.deprecated == nil {
+		r.deprecated = make(map[string]string)
+	}
+	r.deprecated[r.foldKey(oldKey)] = r.foldKey(newKey)
+}
+
+// deprecation returns the key that key is a deprecated
+// name of, if any.
+func (r *replacer) deprecation(key string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	newKey, ok := r.deprecated[key]
+	return newKey, ok
+}
+
+// warnDeprecated logs that oldKey is deprecated in
+// favor of newKey, but only the first time.
+func (r *replacer) warnDeprecated(oldKey, newKey string) {
+	if _, warned := r.warned.LoadOrStore(oldKey, struct{}{}); warned {
+		return
+	}
+	log.Printf("[WARNING] Placeholder {%s} is deprecated; use {%s} instead", oldKey, newKey)
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent writes.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestReplacerDeprecateKey(t *testing.T) {
+	var logs syncBuffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	rep := NewReplacer()
+	rep.Set("server.name", "caddy")
+	rep.DeprecateKey("hostname", "server.name")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if actual := rep.ReplaceAll("{hostname}", ""); actual != "caddy" {
+				t.Errorf("Expected '%s' got '%s'", "caddy", actual)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the new key works silently
+	if actual := rep.ReplaceAll("{server.name}", ""); actual != "caddy" {
+		t.Errorf("Expected '%s' got '%s'", "caddy", actual)
+	}
+
+	if count := strings.Count(logs.String(), "[WARNING]"); count != 1 {
+		t.Errorf("Expected 1 warning, got %d: %s", count, logs.String())
+	}
+	if !strings.Contains(logs.String(), "{hostname} is deprecated; use {server.name} instead") {
+		t.Errorf("Expected warning to suggest the new key, got: %s", logs.String())
+	}
+}
+
+func TestReplacerDeprecateKeyConcurrent(t *testing.T) {
+	log.SetOutput(&syncBuffer{})
+	defer log.SetOutput(os.Stderr)
+
+	rep := NewReplacer()
+	rep.Set("server.name", "caddy")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rep.DeprecateKey("hostname", "server.name")
+		}()
+		go func() {
+			defer wg.Done()
+			rep.ReplaceAll("{hostname}", "")
+		}()
+	}
+	wg.Wait()
+
+	if actual := rep.ReplaceAll("{hostname}", ""); actual != "caddy" {
+		t.Errorf("Expected '%s' got '%s'", "caddy", actual)
+	}
+}
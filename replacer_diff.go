@@ -0,0 +1,52 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+// KeyChange is a placeholder whose value differs
+// between two replacers.
+type KeyChange struct {
+	// Key is the placeholder without its braces,
+	// including any modifiers.
+	Key string
+
+	// Old and New are the values that the first and
+	// the second replacer substitute for it.
+	Old, New string
+}
+
+// RenderDiff renders each placeholder of input with both a
+// and b, as ReplaceAll does with empty, and returns those
+// whose values differ, in the order they first appear.
+// Placeholders are found with the delimiters of a. This
+// tells what a change of configuration would do to the
+// output of a template without comparing whole renders.
+func RenderDiff(input string, a, b Replacer, empty string) []KeyChange {
+	var changes []KeyChange
+	seen := make(map[string]struct{})
+	opener, closer := delimsOf(a)
+	for _, placeholder := range scanPlaceholders(input, opener, closer) {
+		if _, ok := seen[placeholder]; ok {
+			continue
+		}
+		seen[placeholder] = struct{}{}
+
+		whole := opener + placeholder + closer
+		oldVal, newVal := a.ReplaceAll(whole, empty), b.ReplaceAll(whole, empty)
+		if oldVal != newVal {
+			changes = append(changes, KeyChange{Key: placeholder, Old: oldVal, New: newVal})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,48 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRenderDiff(t *testing.T) {
+	a := NewReplacer()
+	a.Set("host", "old.example.com")
+	a.Set("port", "443")
+	a.Set("path", "/a b")
+	a.Set("removed", "gone")
+
+	b := NewReplacer()
+	b.Set("host", "new.example.com")
+	b.Set("port", "443")
+	b.Set("path", "/a b")
+	b.Set("added", "here")
+
+	input := "https://{host}:{port}{path|escape} {host} {removed} {added} {neither} {system.os}"
+	expected := []KeyChange{
+		{Key: "host", Old: "old.example.com", New: "new.example.com"},
+		{Key: "removed", Old: "gone", New: "-"},
+		{Key: "added", Old: "-", New: "here"},
+	}
+	if actual := RenderDiff(input, a, b, "-"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %+v got %+v", expected, actual)
+	}
+
+	if actual := RenderDiff("{port} {path}", a, b, ""); len(actual) != 0 {
+		t.Errorf("Expected no changes, got %+v", actual)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DotEnvFile is an environment file for LoadDotEnvLayers.
+type DotEnvFile struct {
+	// Path is the path of the file.
+	Path string
+
+	// Required makes LoadDotEnvLayers fail if the file
+	// does not exist; otherwise it is skipped.
+	Required bool
+}
+
+// LoadDotEnvLayers reads the environment files, which are in
+// KEY=VALUE format, and returns a provider of their variables
+// as env.KEY placeholders. Files are merged in order, so a
+// variable in a later file, such as .env.local, overrides the
+// same variable in an earlier one, such as .env.
+//
+// Files that do not exist are skipped, unless they are
+// required. Any other error reading or parsing a file is
+// returned.
+//
+// Mapped into a replacer made with NewReplacer, the provider
+// comes after the default replacements, so variables of the
+// actual environment take precedence over those of the files.
+func LoadDotEnvLayers(files ...DotEnvFile) (ReplacementFunc, error) {
+	merged := make(map[string]string)
+	for _, f := range files {
+		file, err := os.Open(f.Path)
+		if os.IsNotExist(err) && !f.Required {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		envMap, err := ParseEnvFile(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Path, err)
+		}
+
+		for key, val := range envMap {
+			merged[key] = val
+		}
+	}
+
+	return func(key string) (string, bool) {
+		if !strings.HasPrefix(key, envPrefix) {
+			return "", false
+		}
+		val, ok := merged[key[len(envPrefix):]]
+		return val, ok
+	}, nil
+}
+
+// ExpandEnv replaces the placeholders in the values of all
+// environment variables using rep, e.g. so that CADDY_URL with
+// the value https://{env.HOST} gets the value of HOST, and sets
+// the results back with os.Setenv. Placeholders that rep does
+// not recognize are left as they are. Expansion is repeated so
+// that variables may refer to variables which refer to others,
+// but at most DefaultExpandDepth times, so variables that refer
+// to each other cannot make it loop forever.
+//
+// It is meant to run once at process start, before anything
+// reads the environment. Variables set afterwards are not
+// expanded, and other placeholders in values are resolved with
+// the providers rep has at that time. Braces in values that are
+// not meant as placeholders, as in JSON, need not be escaped:
+// something like {"port": 80} reads as a placeholder whose key is
+// unknown, and is left as it is, default included. A placeholder
+// within such braces is not expanded, though, since the opening
+// brace pairs with the first closing one. Escaped braces are left
+// as they are, backslashes included.
+func ExpandEnv(rep Replacer) error {
+	opener, _ := delimsOf(rep)
+	for pass := 0; pass < DefaultExpandDepth; pass++ {
+		changed := make(map[string]string)
+		for _, kv := range os.Environ() {
+			idx := strings.Index(kv, "=")
+			if idx <= 0 || !strings.Contains(kv[idx+1:], opener) {
+				continue
+			}
+			if expanded := rep.ReplaceKnown(kv[idx+1:], ""); expanded != kv[idx+1:] {
+				changed[kv[:idx]] = expanded
+			}
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		for key, val := range changed {
+			if err := os.Setenv(key, val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ParseEnvFile implements parse logic for environment files
+func ParseEnvFile(envInput io.Reader) (map[string]string, error) {
+	envMap := make(map[string]string)
+
+	scanner := bufio.NewScanner(envInput)
+	var line string
+	lineNumber := 0
+
+	for scanner.Scan() {
+		line = strings.TrimSpace(scanner.Text())
+		lineNumber++
+
+		// skip lines starting with comment
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// skip empty line
+		if len(line) == 0 {
+			continue
+		}
+
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Can't parse line %d; line should be in KEY=VALUE format", lineNumber)
+		}
+
+		if strings.Contains(fields[0], " ") {
+			return nil, fmt.Errorf("Can't parse line %d; KEY contains whitespace", lineNumber)
+		}
+
+		key := fields[0]
+		val := fields[1]
+
+		if key == "" {
+			return nil, fmt.Errorf("Can't parse line %d; KEY can't be empty string", lineNumber)
+		}
+		envMap[key] = val
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return envMap, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDotEnvLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caddy_dotenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, ".env")
+	local := filepath.Join(dir, ".env.local")
+	bad := filepath.Join(dir, ".env.bad")
+	missing := filepath.Join(dir, ".env.missing")
+	for path, contents := range map[string]string{
+		base:  "# defaults\nDB_HOST=localhost\nDB_PORT=5432\nDEBUG=false\n",
+		local: "DB_HOST=db.local\nDEBUG=\n",
+		bad:   "NOT A PAIR\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	provider, err := LoadDotEnvLayers(DotEnvFile{Path: base}, DotEnvFile{Path: missing}, DotEnvFile{Path: local})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	testProvider(t, provider, []providerTestCase{
+		{key: "env.DB_HOST", expected: "db.local", ok: true},
+		{key: "env.DB_PORT", expected: "5432", ok: true},
+		{key: "env.DEBUG", expected: "", ok: true},
+		{key: "env.UNSET", ok: false},
+		{key: "DB_HOST", ok: false},
+	})
+
+	// variables of the files take precedence over defaults
+	rep := NewReplacer()
+	rep.Map(provider)
+	if actual, expected := rep.ReplaceAll("{env.DB_PORT:1}|{env.UNSET:1}", "-"), "5432|1"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	if _, err := LoadDotEnvLayers(DotEnvFile{Path: base}, DotEnvFile{Path: missing, Required: true}); err == nil {
+		t.Error("Expected error for missing required file, but got none")
+	}
+	if _, err := LoadDotEnvLayers(DotEnvFile{Path: base, Required: true}); err != nil {
+		t.Errorf("Expected no error for present required file, got: %v", err)
+	}
+	if _, err := LoadDotEnvLayers(DotEnvFile{Path: base}, DotEnvFile{Path: bad}); err == nil {
+		t.Error("Expected error for malformed file, but got none")
+	}
+
+	// a name starting with "!" is just a name
+	bang := filepath.Join(dir, "!optional.env")
+	if _, err := LoadDotEnvLayers(DotEnvFile{Path: bang}); err != nil {
+		t.Errorf("Expected no error for missing optional file, got: %v", err)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	for key, val := range map[string]string{
+		"CADDY_EXPAND_HOST":  "example.com",
+		"CADDY_EXPAND_URL":   "https://{env.CADDY_EXPAND_HOST}/{env.CADDY_EXPAND_PATH}",
+		"CADDY_EXPAND_PATH":  "{env.CADDY_EXPAND_SEG}",
+		"CADDY_EXPAND_SEG":   "api",
+		"CADDY_EXPAND_BRACE": "{not a placeholder}",
+		"CADDY_EXPAND_OTHER": "{later.stage}",
+		"CADDY_EXPAND_JSON":  `[{"port": 80}, "{env.CADDY_EXPAND_HOST}"]`,
+		"CADDY_EXPAND_ESC":   `\{"a":1\}`,
+		"CADDY_EXPAND_A":     "a{env.CADDY_EXPAND_B}",
+		"CADDY_EXPAND_B":     "b{env.CADDY_EXPAND_A}",
+	} {
+		os.Setenv(key, val)
+		defer os.Unsetenv(key)
+	}
+
+	if err := ExpandEnv(NewReplacer()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	for key, expected := range map[string]string{
+		"CADDY_EXPAND_URL":   "https://example.com/api",
+		"CADDY_EXPAND_PATH":  "api",
+		"CADDY_EXPAND_BRACE": "{not a placeholder}",
+		"CADDY_EXPAND_OTHER": "{later.stage}",
+		"CADDY_EXPAND_JSON":  `[{"port": 80}, "example.com"]`,
+		"CADDY_EXPAND_ESC":   `\{"a":1\}`,
+	} {
+		if actual := os.Getenv(key); actual != expected {
+			t.Errorf("Expected %s to be '%s' got '%s'", key, expected, actual)
+		}
+	}
+	// variables that refer to each other are expanded a bounded number of times
+	if actual := os.Getenv("CADDY_EXPAND_A"); !strings.HasPrefix(actual, "ab") || !strings.Contains(actual, "{env.CADDY_EXPAND_") {
+		t.Errorf("Expected bounded expansion of a cycle, got '%s'", actual)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"encoding/json"
+	"html/template"
+	"mime"
+	"strings"
+)
+
+// ReplaceAllEscaped is like ReplaceAll, except that each
+// resolved value is escaped for the given content type so
+// that it cannot change the structure of the document:
+// HTML and XML values are HTML-escaped, JavaScript values
+// are JS-escaped and JSON values are escaped for use in a
+// JSON string. For any other content type, values are not
+// escaped. The empty value is never escaped.
+func (r *replacer) ReplaceAllEscaped(input, empty, contentType string) string {
+	out, _ := r.replace(input, empty, replaceOpts{escape: escaperFor(contentType)})
+	return out
+}
+
+// escaperFor returns the escaping function for values
+// embedded in contentType, or nil if there is none.
+func escaperFor(contentType string) func(string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case mediaType == "text/html",
+		mediaType == "application/xhtml+xml",
+		mediaType == "text/xml",
+		mediaType == "application/xml",
+		strings.HasSuffix(mediaType, "+xml"):
+		return template.HTMLEscapeString
+	case mediaType == "application/javascript",
+		mediaType == "text/javascript":
+		return template.JSEscapeString
+	case mediaType == "application/json",
+		strings.HasSuffix(mediaType, "+json"):
+		return jsonEscapeString
+	}
+	return nil
+}
+
+// jsonEscapeString escapes s so that it can be embedded
+// between the quotes of a JSON string.
+func jsonEscapeString(s string) string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	return string(b[1 : len(b)-1])
+}
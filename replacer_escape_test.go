@@ -0,0 +1,78 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestReplaceAllEscaped(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("name", `<script>alert("x & y")</script>`)
+	rep.Set("path", "C:\\dir\n'quoted'")
+
+	for i, tc := range []struct {
+		input       string
+		contentType string
+		expected    string
+	}{
+		{
+			input:       "<p>{name}</p>",
+			contentType: "text/html; charset=utf-8",
+			expected:    "<p>&lt;script&gt;alert(&#34;x &amp; y&#34;)&lt;/script&gt;</p>",
+		},
+		{
+			input:       `["{name}", "{path}"]`,
+			contentType: "application/json",
+			expected:    `["\u003cscript\u003ealert(\"x \u0026 y\")\u003c/script\u003e", "C:\\dir\n'quoted'"]`,
+		},
+		{
+			input:       "var p = '{path}';",
+			contentType: "application/javascript",
+			expected:    `var p = 'C:\\dir\u000A\'quoted\'';`,
+		},
+		{
+			input:       "{name}",
+			contentType: "text/plain",
+			expected:    `<script>alert("x & y")</script>`,
+		},
+		{
+			input:       "{name}",
+			contentType: "invalid/",
+			expected:    `<script>alert("x & y")</script>`,
+		},
+		{
+			// the empty value is not escaped
+			input:       "<p>{missing}</p>",
+			contentType: "text/html",
+			expected:    "<p><none></p>",
+		},
+	} {
+		if actual := rep.ReplaceAllEscaped(tc.input, "<none>", tc.contentType); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// JSON output must remain valid JSON with the original values
+	out := rep.ReplaceAllEscaped(`["{name}", "{path}"]`, "", "application/json")
+	var decoded []string
+	if err := json.Unmarshal([]byte(out), &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got error: %v", err)
+	}
+	if len(decoded) != 2 || decoded[0] != `<script>alert("x & y")</script>` || decoded[1] != "C:\\dir\n'quoted'" {
+		t.Errorf("Expected decoded values to match originals, got %v", decoded)
+	}
+}
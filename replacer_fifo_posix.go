@@ -0,0 +1,81 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// +build !windows,!plan9,!nacl,!js
+
+package caddy
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// LoadFIFOProvider returns a provider which resolves keys of
+// the form fifo.path, e.g. {fifo./run/secret.pipe}, to what a
+// writer sends through the named pipe at path, without
+// trailing newlines. Since reading a named pipe blocks until
+// a writer opens it and closes it again, lookups give up
+// after timeout, and the key is then not recognized, as it is
+// if the pipe cannot be read.
+func LoadFIFOProvider(timeout time.Duration) ReplacementFunc {
+	const prefix = "fifo."
+	return func(key string) (string, bool) {
+		if !strings.HasPrefix(key, prefix) || len(key) == len(prefix) {
+			return "", false
+		}
+		return readFIFO(key[len(prefix):], timeout)
+	}
+}
+
+// readFIFO reads the named pipe at path until the
+// writer closes it, for at most timeout.
+func readFIFO(path string, timeout time.Duration) (string, bool) {
+	type result struct {
+		contents string
+		err      error
+	}
+	deadline := time.Now().Add(timeout)
+	done := make(chan result, 1)
+	go func() {
+		// opening blocks until there is a writer
+		f, err := os.Open(path)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer f.Close()
+		f.SetReadDeadline(deadline)
+		b, err := ioutil.ReadAll(f)
+		done <- result{contents: string(b), err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return "", false
+		}
+		return strings.TrimRight(res.contents, "\r\n"), true
+	case <-timer.C:
+		// if the reader is still waiting for a writer,
+		// briefly become one so that it can return
+		if w, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			w.Close()
+		}
+		return "", false
+	}
+}
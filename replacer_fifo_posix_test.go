@@ -0,0 +1,70 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// +build !windows,!plan9,!nacl,!js
+
+package caddy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLoadFIFOProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caddy_fifo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pipe := filepath.Join(dir, "secret.pipe")
+	if err := syscall.Mkfifo(pipe, 0600); err != nil {
+		t.Skipf("Cannot make named pipe: %v", err)
+	}
+
+	rep := NewReplacer()
+	rep.Map(LoadFIFOProvider(time.Second))
+
+	go func() {
+		f, err := os.OpenFile(pipe, os.O_WRONLY, 0)
+		if err != nil {
+			t.Errorf("Opening pipe for writing: %v", err)
+			return
+		}
+		f.WriteString("s3cret\n")
+		f.Close()
+	}()
+	if actual := rep.ReplaceAll("{fifo."+pipe+"}", "-"); actual != "s3cret" {
+		t.Errorf("Expected '%s' got '%s'", "s3cret", actual)
+	}
+
+	// without a writer, the lookup times out
+	rep = NewReplacer()
+	rep.Map(LoadFIFOProvider(50 * time.Millisecond))
+	start := time.Now()
+	if actual := rep.ReplaceAll("{fifo."+pipe+"}", "-"); actual != "-" {
+		t.Errorf("Expected '%s' got '%s'", "-", actual)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected lookup to time out quickly, took %v", elapsed)
+	}
+
+	testProvider(t, LoadFIFOProvider(50*time.Millisecond), []providerTestCase{
+		{key: "fifo." + filepath.Join(dir, "missing"), ok: false},
+		{key: "fifo.", ok: false},
+		{key: "other", ok: false},
+	})
+}
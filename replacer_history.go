@@ -0,0 +1,131 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RememberKey makes r remember the last depth values that key
+// had before its current one, so that {key.prev} resolves to
+// the value before the current one and {key.prev.2} up to
+// {key.prev.<depth>} to those before that. A value is recorded
+// whenever key is resolved to a value different from the last
+// one, so the history follows the transitions of its state.
+// Until key has had enough values, the older ones are unknown.
+// A depth of 0 or less forgets key. Like EnableInterning,
+// RememberKey must be called before r is shared.
+func (r *replacer) RememberKey(key string, depth int) {
+	if r.history == nil {
+		if depth <= 0 {
+			return
+		}
+		r.history = &keyHistory{rings: make(map[string]*valueRing)}
+		r.addProvider(provider{
+			replace: infallible(r.history.replace),
+			source:  "history",
+			rebind:  func(r *replacer) ReplacementErrFunc { return infallible(r.history.replace) },
+		})
+	}
+	r.history.remember(r.foldKey(key), depth)
+}
+
+// keyHistory holds the recent values of remembered keys.
+type keyHistory struct {
+	mu    sync.Mutex
+	rings map[string]*valueRing
+}
+
+// valueRing is the history of one key.
+type valueRing struct {
+	depth int
+
+	// vals has the most recent value first and
+	// at most depth values before it
+	vals []string
+}
+
+// remember starts or stops remembering key,
+// keeping the values it already has.
+func (h *keyHistory) remember(key string, depth int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if depth <= 0 {
+		delete(h.rings, key)
+		return
+	}
+	ring, ok := h.rings[key]
+	if !ok {
+		ring = new(valueRing)
+		h.rings[key] = ring
+	}
+	ring.depth = depth
+	if len(ring.vals) > depth+1 {
+		ring.vals = ring.vals[:depth+1]
+	}
+}
+
+// record notes that key resolved to val,
+// if key is remembered.
+func (h *keyHistory) record(key, val string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ring, ok := h.rings[key]
+	if !ok || (len(ring.vals) > 0 && ring.vals[0] == val) {
+		return
+	}
+	if len(ring.vals) <= ring.depth {
+		ring.vals = append(ring.vals, "")
+	}
+	copy(ring.vals[1:], ring.vals)
+	ring.vals[0] = val
+}
+
+// replace resolves keys of the form key.prev
+// and key.prev.n of remembered keys.
+func (h *keyHistory) replace(key string) (string, bool) {
+	n := 1
+	if idx := strings.LastIndex(key, ".prev."); idx >= 0 {
+		var err error
+		if n, err = strconv.Atoi(key[idx+len(".prev."):]); err != nil || n < 1 {
+			return "", false
+		}
+		key = key[:idx]
+	} else if strings.HasSuffix(key, ".prev") {
+		key = strings.TrimSuffix(key, ".prev")
+	} else {
+		return "", false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ring, ok := h.rings[key]
+	if !ok || n >= len(ring.vals) {
+		return "", false
+	}
+	return ring.vals[n], true
+}
+
+// clone returns a copy of h.
+func (h *keyHistory) clone() *keyHistory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := &keyHistory{rings: make(map[string]*valueRing, len(h.rings))}
+	for key, ring := range h.rings {
+		c.rings[key] = &valueRing{depth: ring.depth, vals: append([]string(nil), ring.vals...)}
+	}
+	return c
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import "testing"
+
+func TestReplacerRememberKey(t *testing.T) {
+	rep := NewReplacer()
+	rep.RememberKey("state", 2)
+
+	const input = "{state}|{state.prev}|{state.prev.2}|{state.prev.3}"
+	for i, tc := range []struct {
+		state    string
+		expected string
+	}{
+		{state: "starting", expected: "starting|-|-|-"},
+		{state: "starting", expected: "starting|-|-|-"},
+		{state: "running", expected: "running|starting|-|-"},
+		{state: "stopping", expected: "stopping|running|starting|-"},
+		{state: "stopped", expected: "stopped|stopping|running|-"},
+		{state: "stopped", expected: "stopped|stopping|running|-"},
+	} {
+		rep.Set("state", tc.state)
+		if actual := rep.ReplaceAll(input, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// a lower depth drops the oldest values
+	rep.RememberKey("state", 1)
+	if actual, expected := rep.ReplaceAll(input, "-"), "stopped|stopping|-|-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	// keys that are not remembered have no history
+	rep.Set("other", "a")
+	rep.ReplaceAll("{other}", "")
+	rep.Set("other", "b")
+	if actual, expected := rep.ReplaceAll("{other}|{other.prev}|{state.prev.x}", "-"), "b|-|-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	rep.RememberKey("state", 0)
+	if actual, expected := rep.ReplaceAll(input, "-"), "stopped|-|-|-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import "sync"
+
+// EnableInterning makes r deduplicate the strings it
+// renders: identical outputs share the same backing
+// storage instead of each being allocated anew. At most
+// max distinct strings are remembered; once the cache is
+// full, new strings are returned without being cached.
+func (r *replacer) EnableInterning(max int) {
+	if max <= 0 {
+		r.interner = nil
+		return
+	}
+	r.interner = &interner{
+		max:   max,
+		cache: make(map[string]string),
+	}
+}
+
+// interner is a bounded cache of strings.
+type interner struct {
+	max   int
+	cache map[string]string
+	mu    sync.Mutex
+}
+
+// intern returns a string with the contents of b,
+// reusing a previously returned one if possible.
+func (in *interner) intern(b []byte) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	// the compiler does not allocate for this conversion
+	if s, ok := in.cache[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	if len(in.cache) < in.max {
+		in.cache[s] = s
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReplacerInterning(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("a", "alpha")
+	rep.Set("b", "beta")
+	rep.EnableInterning(2)
+
+	first := rep.ReplaceAll("{a}-{b}", "")
+	second := rep.ReplaceAll("{a}-{b}", "")
+	if first != "alpha-beta" || second != first {
+		t.Fatalf("Expected '%s' twice, got '%s' and '%s'", "alpha-beta", first, second)
+	}
+	allocs := testing.AllocsPerRun(10, func() { rep.ReplaceAll("{a}-{b}", "") })
+	if allocs != 0 {
+		t.Errorf("Expected interned output not to allocate, got %v allocs", allocs)
+	}
+
+	// fill the cache; further strings are not cached
+	rep.ReplaceAll("{b}", "")
+	allocs = testing.AllocsPerRun(10, func() { rep.ReplaceAll("{a}", "") })
+	if allocs == 0 {
+		t.Errorf("Expected strings beyond the cache size not to be interned")
+	}
+	if in := rep.(*replacer).interner; len(in.cache) != 2 {
+		t.Errorf("Expected cache size %d, got %d", 2, len(in.cache))
+	}
+
+	// disabling works
+	rep.EnableInterning(0)
+	if actual := rep.ReplaceAll("{a}", ""); actual != "alpha" {
+		t.Errorf("Expected '%s' got '%s'", "alpha", actual)
+	}
+}
+
+func BenchmarkReplacerInterning(b *testing.B) {
+	for _, size := range []int{0, 16} {
+		b.Run(fmt.Sprintf("max=%d", size), func(b *testing.B) {
+			rep := NewReplacer()
+			rep.Set("host", "example.com")
+			rep.Set("port", "443")
+			rep.EnableInterning(size)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rep.ReplaceAll("https://{host}:{port}/", "")
+			}
+		})
+	}
+}
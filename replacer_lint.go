@@ -0,0 +1,246 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LintPlaceholders returns the placeholders of input, with
+// their braces, whose keys do not start with any of
+// knownPrefixes, in the order they first appear. Modifiers
+// and defaults are not part of the key. This can
+// catch typos like {sytem.hostname} in a config before it
+// is deployed, without needing the providers that will
+// resolve the placeholders at runtime.
+func LintPlaceholders(input string, knownPrefixes []string) []string {
+	var unsupported []string
+	seen := make(map[string]struct{})
+	for _, placeholder := range scanPlaceholders(input, phOpen, phClose) {
+		if _, ok := seen[placeholder]; ok {
+			continue
+		}
+		seen[placeholder] = struct{}{}
+		if !hasAnyPrefix(placeholderKey(placeholder), knownPrefixes) {
+			unsupported = append(unsupported, phOpen+placeholder+phClose)
+		}
+	}
+	return unsupported
+}
+
+// ValidatePlaceholders checks the structure of the placeholders
+// of input, without resolving them, and returns an error naming
+// the first problem and its byte offset. Replacement is lenient
+// about these problems, so this is for rejecting templates that
+// are likely wrong, such as user-supplied ones. The problems are
+// a '{' without a matching '}' on the same line, which would be
+// left as-is, an empty placeholder, a '{' within a placeholder
+// and a '}' outside of one. Escaped braces are fine.
+func ValidatePlaceholders(input string) error {
+	for i := 0; i < len(input); i++ {
+		if input[i] == escapeChar[0] {
+			if n := escapedLen(input[i+1:], phOpen, phClose); n > 0 {
+				i += n
+				continue
+			}
+		}
+		if strings.HasPrefix(input[i:], phClose) {
+			return fmt.Errorf("offset %d: '%s' without a matching '%s'", i, phClose, phOpen)
+		}
+		if !strings.HasPrefix(input[i:], phOpen) {
+			continue
+		}
+		start := i + len(phOpen)
+		end := strings.Index(input[start:], phClose)
+		if nl := strings.IndexByte(input[start:], '\n'); end < 0 || (nl >= 0 && nl < end) {
+			return fmt.Errorf("offset %d: '%s' without a matching '%s' on the same line", i, phOpen, phClose)
+		}
+		placeholder := input[start : start+end]
+		if placeholder == "" {
+			return fmt.Errorf("offset %d: empty placeholder", i)
+		}
+		if idx := strings.Index(placeholder, phOpen); idx >= 0 {
+			return fmt.Errorf("offset %d: '%s' within placeholder %s%s%s", start+idx, phOpen, phOpen, placeholder, phClose)
+		}
+		i = start + end + len(phClose) - 1
+	}
+	return nil
+}
+
+// scanPlaceholders returns the placeholders of input without
+// their delimiters, opener and closer, in order, found the
+// same way replacement finds them.
+func scanPlaceholders(input, opener, closer string) []string {
+	var placeholders []string
+	for i := 0; i < len(input); i++ {
+		if input[i] == escapeChar[0] {
+			if n := escapedLen(input[i+1:], opener, closer); n > 0 {
+				i += n
+				continue
+			}
+		}
+		if !strings.HasPrefix(input[i:], opener) {
+			continue
+		}
+		start := i + len(opener)
+		end := strings.Index(input[start:], closer)
+		if end < 0 {
+			break
+		}
+		placeholders = append(placeholders, input[start:start+end])
+		i = start + end + len(closer) - 1
+	}
+	return placeholders
+}
+
+// delimsOf returns the delimiters of placeholders for rep.
+func delimsOf(rep Replacer) (opener, closer string) {
+	if r, ok := rep.(*replacer); ok {
+		return r.delims()
+	}
+	return phOpen, phClose
+}
+
+// placeholderKey returns the key of placeholder, which
+// is what comes before any modifiers or default.
+func placeholderKey(placeholder string) string {
+	if idx := strings.Index(placeholder, modSep); idx >= 0 {
+		placeholder = placeholder[:idx]
+	}
+	if idx := strings.Index(placeholder, defaultSep); idx >= 0 {
+		placeholder = placeholder[:idx]
+	}
+	return placeholder
+}
+
+// hasAnyPrefix reports whether s starts with any of prefixes.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyStatus describes whether a placeholder of a
+// template can be resolved, and where its value
+// would come from.
+type KeyStatus struct {
+	// Placeholder is the placeholder without its braces,
+	// and Key is its key, without modifiers or default.
+	Placeholder, Key string
+
+	// Resolvable is true if the placeholder can be
+	// replaced without error.
+	Resolvable bool
+
+	// Source names the provider that recognizes the key:
+	// SourceStatic for values made with Set, SourceDefault
+	// for the default replacements, or the name of an
+	// enumerable provider. It is empty for other providers,
+	// including those of replacers that are not made by
+	// this package, and for keys that are not recognized.
+	Source string
+}
+
+// Resolvability reports, for each distinct placeholder of
+// input in the order they first appear, whether rep can
+// resolve it and what would supply its value. Unlike
+// LintPlaceholders, it consults the providers of rep, so
+// it is a preflight check with the actual configuration.
+func Resolvability(input string, rep Replacer) []KeyStatus {
+	var statuses []KeyStatus
+	seen := make(map[string]struct{})
+	opener, closer := delimsOf(rep)
+	for _, placeholder := range scanPlaceholders(input, opener, closer) {
+		if _, ok := seen[placeholder]; ok {
+			continue
+		}
+		seen[placeholder] = struct{}{}
+
+		status := KeyStatus{Placeholder: placeholder, Key: placeholderKey(placeholder)}
+		_, err := rep.ReplaceOrErr(opener+placeholder+closer, false, true)
+		status.Resolvable = err == nil
+		if r, ok := rep.(*replacer); ok {
+			status.Source = r.sourceOf(status.Key)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Reasons reported by ResolveReport for keys that
+// could not be resolved, besides errors.
+const (
+	ReasonUnknown = "unknown provider"
+	ReasonEmpty   = "empty"
+)
+
+// ResolveReport resolves the placeholders of input with rep
+// and maps the key of each one that does not resolve to a
+// value to the reason why: ReasonUnknown if no provider
+// recognizes it, ReasonEmpty if its value is empty, or
+// "provider error: " or "modifier error: " followed by the
+// error for placeholders that fail. If a key appears in
+// several placeholders, the first one that does not resolve
+// gives the reason. Keys that resolve are not included.
+func ResolveReport(input string, rep Replacer) map[string]string {
+	report := make(map[string]string)
+	opener, closer := delimsOf(rep)
+	for _, placeholder := range scanPlaceholders(input, opener, closer) {
+		key := placeholderKey(placeholder)
+		if _, ok := report[key]; ok {
+			continue
+		}
+		wrapped := opener + placeholder + closer
+		out, err := rep.ReplaceAllErr(wrapped, "")
+		if err != nil {
+			report[key] = failureReason(rep, key, err)
+			continue
+		}
+		if _, err := rep.ReplaceOrErr(wrapped, false, true); err != nil {
+			report[key] = ReasonUnknown
+		} else if out == "" {
+			report[key] = ReasonEmpty
+		}
+	}
+	return report
+}
+
+// failureReason describes err, the error of resolving a
+// placeholder of key with rep, for ResolveReport.
+func failureReason(rep Replacer, key string, err error) string {
+	if r, ok := rep.(*replacer); ok {
+		if _, _, lookupErr := r.lookup(key); lookupErr != nil {
+			return "provider error: " + lookupErr.Error()
+		}
+	}
+	return "modifier error: " + err.Error()
+}
+
+// sourceOf returns the source of the
+// provider that recognizes key.
+func (r *replacer) sourceOf(key string) string {
+	for _, p := range r.providerList() {
+		if _, ok, err := p.replace(key); err != nil {
+			return ""
+		} else if ok {
+			return p.source
+		}
+	}
+	return ""
+}
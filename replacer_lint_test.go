@@ -0,0 +1,134 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestLintPlaceholders(t *testing.T) {
+	known := []string{"system.", "env.", "http.request."}
+
+	for i, tc := range []struct {
+		input    string
+		expected []string
+	}{
+		{input: "no placeholders", expected: nil},
+		{input: "{system.hostname} {env.HOME|escape}", expected: nil},
+		{input: "{http.request.uri}{env.PORT:$(echo 80)}", expected: nil},
+		{input: "{sytem.hostname} {system.os}", expected: []string{"{sytem.hostname}"}},
+		{input: "{envv.HOME|escape} {x} {envv.HOME|escape} {x}", expected: []string{"{envv.HOME|escape}", "{x}"}},
+		{input: "{system.os} {unterminated", expected: nil},
+		{input: "{}", expected: []string{"{}"}},
+		{input: `\{sytem.hostname\} {x}`, expected: []string{"{x}"}},
+	} {
+		if actual := LintPlaceholders(tc.input, known); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("Test %d: Expected %q got %q", i, tc.expected, actual)
+		}
+	}
+
+	if actual := LintPlaceholders("{a} {b}", nil); !reflect.DeepEqual(actual, []string{"{a}", "{b}"}) {
+		t.Errorf("Expected all placeholders without known prefixes, got %q", actual)
+	}
+}
+
+// namedProvider is a mapProvider with a name.
+type namedProvider struct {
+	mapProvider
+	name string
+}
+
+func (n namedProvider) String() string { return n.name }
+
+func TestValidatePlaceholders(t *testing.T) {
+	for i, tc := range []struct {
+		input     string
+		expectErr string
+	}{
+		{input: ""},
+		{input: "no placeholders"},
+		{input: "{host}:{port:80}/{path|upper}"},
+		{input: "line {one}\nline {two}"},
+		{input: "\\{literal\\} and \\} and \\{"},
+		{input: "{host} {port", expectErr: "offset 7: '{' without a matching '}' on the same line"},
+		{input: "{host\n}", expectErr: "offset 0: '{' without a matching '}' on the same line"},
+		{input: "ok {} not", expectErr: "offset 3: empty placeholder"},
+		{input: "{a {b}", expectErr: "offset 3: '{' within placeholder {a {b}"},
+		{input: "{host}}", expectErr: "offset 6: '}' without a matching '{'"},
+		{input: "stray } brace", expectErr: "offset 6: '}' without a matching '{'"},
+	} {
+		err := ValidatePlaceholders(tc.input)
+		if tc.expectErr == "" && err != nil {
+			t.Errorf("Test %d: Expected no error, got: %v", i, err)
+		}
+		if tc.expectErr != "" && (err == nil || err.Error() != tc.expectErr) {
+			t.Errorf("Test %d: Expected error '%s', got: %v", i, tc.expectErr, err)
+		}
+	}
+}
+
+func TestResolvability(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("site", "example.com")
+	rep.MapEnumerable(namedProvider{mapProvider: mapProvider{"vault.db": "secret"}, name: "vault"})
+	rep.MapEnumerable(mapProvider{"tenant.id": "acme"})
+	rep.Map(func(key string) (string, bool) {
+		return "x", key == "anonymous"
+	})
+
+	input := "{site} {system.os|escape} {vault.db} {tenant.id} {anonymous} {missing} {missing:fallback} {site|nope} {site}"
+	expected := []KeyStatus{
+		{Placeholder: "site", Key: "site", Resolvable: true, Source: SourceStatic},
+		{Placeholder: "system.os|escape", Key: "system.os", Resolvable: true, Source: SourceDefault},
+		{Placeholder: "vault.db", Key: "vault.db", Resolvable: true, Source: "vault"},
+		{Placeholder: "tenant.id", Key: "tenant.id", Resolvable: true, Source: "caddy.mapProvider"},
+		{Placeholder: "anonymous", Key: "anonymous", Resolvable: true},
+		{Placeholder: "missing", Key: "missing"},
+		{Placeholder: "missing:fallback", Key: "missing", Resolvable: true},
+		{Placeholder: "site|nope", Key: "site", Source: SourceStatic},
+	}
+	if actual := Resolvability(input, rep); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%+v\ngot:\n%+v", expected, actual)
+	}
+}
+
+func TestResolveReport(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("site", "example.com")
+	rep.Set("blank", "")
+	rep.MapErr(func(key string) (string, bool, error) {
+		if key == "vault.db" {
+			return "", false, fmt.Errorf("vault sealed")
+		}
+		return "", false, nil
+	})
+
+	input := "{site} {missing} {missing:fallback} {blank} {vault.db} {site|mask} {gone|required} {blank|default:x} {missing|upper}"
+	expected := map[string]string{
+		"missing":  ReasonUnknown,
+		"blank":    ReasonEmpty,
+		"vault.db": "provider error: vault sealed",
+		"site":     "modifier error: {site|mask}: mask: expected number of characters to reveal, got 0 arguments",
+		"gone":     "modifier error: {gone|required}: required key 'gone' is unknown",
+	}
+	if actual := ResolveReport(input, rep); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, actual)
+	}
+	if actual := ResolveReport("{site} {missing:fallback}", rep); len(actual) != 0 {
+		t.Errorf("Expected empty report, got %v", actual)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExpandList replaces the placeholders of input with rep, like
+// ReplaceAll with an empty value of empty string, and expands
+// blocks of the form {each key as name}body{endeach} once per
+// item of the list that is the value of key, with {name} bound
+// to the item. This turns a list, such as {env.HOSTS}, into
+// repeated lines of config:
+//
+//	{each env.HOSTS as h}proxy / {h}{endeach}
+//
+// Items are separated by sep, or by whitespace if sep is empty;
+// they are trimmed and empty ones are skipped. If body does not
+// end with a newline, the expansions for the items are put on
+// separate lines. Blocks cannot be nested.
+func ExpandList(input string, rep Replacer, sep string) (string, error) {
+	opener, closer := delimsOf(rep)
+	const eachPrefix, endEach = "each ", "endeach"
+	var sb strings.Builder
+	for {
+		start := strings.Index(input, opener+eachPrefix)
+		if start < 0 {
+			break
+		}
+		sb.WriteString(rep.ReplaceAll(input[:start], ""))
+
+		headerEnd := strings.Index(input[start:], closer)
+		if headerEnd < 0 {
+			return "", fmt.Errorf("offset %d: unterminated each block", start)
+		}
+		headerEnd += start
+		header := strings.Fields(input[start+len(opener)+len(eachPrefix) : headerEnd])
+		if len(header) != 3 || header[1] != "as" {
+			return "", fmt.Errorf("offset %d: expected %skey as name%s", start, opener+eachPrefix, closer)
+		}
+		key, name := header[0], header[2]
+
+		bodyStart := headerEnd + len(closer)
+		bodyEnd := strings.Index(input[bodyStart:], opener+endEach+closer)
+		if bodyEnd < 0 {
+			return "", fmt.Errorf("offset %d: each block without %s", start, opener+endEach+closer)
+		}
+		bodyEnd += bodyStart
+		body := input[bodyStart:bodyEnd]
+
+		items := listItems(rep.ReplaceAll(opener+key+closer, ""), sep)
+		for i, item := range items {
+			sb.WriteString(rep.ReplaceAllWith(body, "", map[string]string{name: item}))
+			if i < len(items)-1 && !strings.HasSuffix(body, "\n") {
+				sb.WriteByte('\n')
+			}
+		}
+		input = input[bodyEnd+len(opener+endEach+closer):]
+	}
+	sb.WriteString(rep.ReplaceAll(input, ""))
+	return sb.String(), nil
+}
+
+// listItems splits list at sep, or at whitespace if
+// sep is empty, into trimmed items that are not empty.
+func listItems(list, sep string) []string {
+	if sep == "" {
+		return strings.Fields(list)
+	}
+	var items []string
+	for _, item := range strings.Split(list, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExpandList(t *testing.T) {
+	os.Setenv("CADDY_REPLACER_HOSTS", "10.0.0.1, 10.0.0.2,,10.0.0.3 ")
+	defer os.Unsetenv("CADDY_REPLACER_HOSTS")
+	rep := NewReplacer()
+	rep.Set("site", "example.com")
+	rep.Set("ports", "80 443\t8080")
+	rep.Set("letters", "x,y")
+	rep.Set("none", "")
+
+	for i, tc := range []struct {
+		input     string
+		sep       string
+		expected  string
+		shouldErr bool
+	}{
+		{
+			input:    "{site} {\n{each env.CADDY_REPLACER_HOSTS as h}  proxy / {h}{endeach}\n}",
+			sep:      ",",
+			expected: "example.com {\n  proxy / 10.0.0.1\n  proxy / 10.0.0.2\n  proxy / 10.0.0.3\n}",
+		},
+		{
+			input:    "{each ports as p}bind {site}:{p|upper}\n{endeach}done",
+			expected: "bind example.com:80\nbind example.com:443\nbind example.com:8080\ndone",
+		},
+		{
+			input:    "a{each letters as l}[{l}]{endeach}b{each env.CADDY_REPLACER_HOSTS as h}<{h}>{endeach}c",
+			sep:      ",",
+			expected: "a[x]\n[y]b<10.0.0.1>\n<10.0.0.2>\n<10.0.0.3>c",
+		},
+		{input: "before\n{each none as n}line {n}\n{endeach}after", expected: "before\nafter"},
+		{input: "before\n{each missing as n}line {n}\n{endeach}after", expected: "before\nafter"},
+		{input: "{each ports}{endeach}", shouldErr: true},
+		{input: "{each ports as p}line", shouldErr: true},
+		{input: "{each ports as p", shouldErr: true},
+	} {
+		actual, err := ExpandList(tc.input, rep, tc.sep)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: Expected error, got '%s'", i, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: Unexpected error: %v", i, err)
+		}
+		if actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Literal returns a placeholder that r renders as exactly
+// s. It is meant for embedding untrusted input, such as
+// user-supplied text, in a template next to trusted
+// placeholders: any braces in s are never interpreted.
+// Literals are resolved before any provider, and their
+// keys are not included in Keys.
+//
+// A literal is kept, and copied by Clone, until it is
+// released with ReleaseLiteral. Literals made for a single
+// render of a long-lived replacer must be released once
+// it is done, or be made on a clone that is discarded
+// afterwards, or they accumulate.
+func (r *replacer) Literal(s string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.literals == nil {
+		r.literals = make(map[string]string)
+	}
+	key := literalPrefix + strconv.FormatUint(r.literalSeq, 10)
+	r.literalSeq++
+	r.literals[key] = s
+	return r.wrap(key)
+}
+
+// ReleaseLiteral forgets the literal of placeholder, as
+// returned by Literal, after which placeholder is no
+// longer resolved. Other placeholders are ignored.
+func (r *replacer) ReleaseLiteral(placeholder string) {
+	opener, closer := r.delims()
+	if !strings.HasPrefix(placeholder, opener) || !strings.HasSuffix(placeholder, closer) {
+		return
+	}
+	r.mu.Lock()
+	delete(r.literals, placeholder[len(opener):len(placeholder)-len(closer)])
+	r.mu.Unlock()
+}
+
+// literal returns the value of the literal with key.
+func (r *replacer) literal(key string) (string, bool) {
+	if !strings.HasPrefix(key, literalPrefix) {
+		return "", false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	val, ok := r.literals[key]
+	return val, ok
+}
+
+// literalPrefix starts the keys of literals. The NUL
+// byte keeps them from colliding with keys that can
+// appear in templates written by hand.
+const literalPrefix = "\x00literal."
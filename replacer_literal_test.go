@@ -0,0 +1,59 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import "testing"
+
+func TestReplacerLiteral(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("user", "{secret}")
+	rep.Set("secret", "leaked")
+
+	untrusted := "{secret} and {system.os|escape}"
+	template := "Hello " + rep.Literal(untrusted) + ", " + rep.Literal("{") + "!"
+
+	expected := "Hello {secret} and {system.os|escape}, {!"
+	if actual := rep.ReplaceAll(template, ""); actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	// each literal gets its own key, which is not enumerated
+	if keys := rep.Keys(); len(keys) != 2 {
+		t.Errorf("Expected literals not to be listed in keys, got %v", keys)
+	}
+	if a, b := rep.Literal("x"), rep.Literal("x"); a == b {
+		t.Errorf("Expected distinct placeholders for each literal, got '%s' twice", a)
+	}
+}
+
+func TestReplacerReleaseLiteral(t *testing.T) {
+	rep := NewReplacer()
+	a := rep.Literal("a")
+	b := rep.Literal("b")
+	rep.ReleaseLiteral(a)
+	rep.ReleaseLiteral("{system.os}")
+
+	if actual, expected := rep.ReplaceAll(a+b, "-"), "-b"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	if actual := len(rep.(*replacer).literals); actual != 1 {
+		t.Errorf("Expected %d literal to be kept, got %d", 1, actual)
+	}
+
+	// keys of released literals are not reused
+	if c := rep.Literal("c"); c == a || c == b {
+		t.Errorf("Expected a fresh placeholder, got '%s'", c)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"log"
+	"time"
+)
+
+// MetricsSink receives an observation for every key a
+// replacer looks up: how long it took to consult the
+// providers, and whether one of them recognized the key.
+// Implementations must be safe for concurrent use.
+type MetricsSink interface {
+	ObserveResolve(key string, dur time.Duration, ok bool)
+}
+
+// SetMetricsSink makes r report lookups to sink. A
+// nil sink turns reporting off, which costs nothing.
+func (r *replacer) SetMetricsSink(sink MetricsSink) {
+	r.metrics = sink
+}
+
+// lookupObserved is like lookupProviders, but reports the
+// lookup to r.metrics. A miss is only reported if final is
+// true.
+func (r *replacer) lookupObserved(providers []provider, key string, final bool, scope *lookupScope) (string, bool, error) {
+	start := time.Now()
+	for _, p := range providers {
+		if val, ok, err := r.callProvider(p, key, scope); err != nil || ok {
+			ok = ok && err == nil
+			r.metrics.ObserveResolve(key, time.Since(start), ok)
+			return val, ok, err
+		}
+	}
+	if final {
+		r.metrics.ObserveResolve(key, time.Since(start), false)
+	}
+	return "", false, nil
+}
+
+// SetSlowThreshold makes r log a warning with the key and the
+// duration of every call to a provider that takes longer than
+// threshold. This is lighter than a MetricsSink for finding
+// slow providers. Warnings go to logger, or to the standard
+// logger if it is nil. A threshold of 0 turns logging off.
+func (r *replacer) SetSlowThreshold(threshold time.Duration, logger *log.Logger) {
+	r.slowThreshold, r.slowLogger = threshold, logger
+}
+
+// callProvider looks up key with p, logging the call if
+// it is slower than r.slowThreshold. The lookup is
+// within scope, which may be nil.
+func (r *replacer) callProvider(p provider, key string, scope *lookupScope) (string, bool, error) {
+	if r.slowThreshold <= 0 {
+		return p.call(key, scope)
+	}
+	start := time.Now()
+	val, ok, err := p.call(key, scope)
+	if dur := time.Since(start); dur > r.slowThreshold {
+		const format = "[WARNING] Slow placeholder resolution: {%s} took %v"
+		if r.slowLogger != nil {
+			r.slowLogger.Printf(format, key, dur)
+		} else {
+			log.Printf(format, key, dur)
+		}
+	}
+	return val, ok, err
+}
@@ -0,0 +1,143 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type observation struct {
+	key string
+	dur time.Duration
+	ok  bool
+}
+
+type fakeSink struct {
+	mu           sync.Mutex
+	observations []observation
+}
+
+func (s *fakeSink) ObserveResolve(key string, dur time.Duration, ok bool) {
+	s.mu.Lock()
+	s.observations = append(s.observations, observation{key, dur, ok})
+	s.mu.Unlock()
+}
+
+func TestReplacerMetricsSink(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("fast", "f")
+	rep.Map(func(key string) (string, bool) {
+		if key == "slow" {
+			time.Sleep(5 * time.Millisecond)
+			return "s", true
+		}
+		return "", false
+	})
+
+	// nothing is observed without a sink
+	rep.ReplaceAll("{fast}", "")
+
+	sink := new(fakeSink)
+	rep.SetMetricsSink(sink)
+	if actual := rep.ReplaceAll("{fast}{slow}{missing}", ""); actual != "fs" {
+		t.Errorf("Expected '%s' got '%s'", "fs", actual)
+	}
+
+	if len(sink.observations) != 3 {
+		t.Fatalf("Expected 3 observations, got %d: %v", len(sink.observations), sink.observations)
+	}
+	for i, expected := range []observation{
+		{key: "fast", ok: true},
+		{key: "slow", ok: true, dur: 5 * time.Millisecond},
+		{key: "missing", ok: false},
+	} {
+		actual := sink.observations[i]
+		if actual.key != expected.key || actual.ok != expected.ok {
+			t.Errorf("Observation %d: Expected key '%s' ok=%t, got key '%s' ok=%t",
+				i, expected.key, expected.ok, actual.key, actual.ok)
+		}
+		if actual.dur < expected.dur {
+			t.Errorf("Observation %d: Expected duration of at least %s, got %s", i, expected.dur, actual.dur)
+		}
+	}
+
+	rep.SetMetricsSink(nil)
+	rep.ReplaceAll("{fast}", "")
+	if len(sink.observations) != 3 {
+		t.Errorf("Expected no observations after removing the sink, got %d", len(sink.observations))
+	}
+}
+
+func TestReplacerMetricsSinkFinalOnly(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("fast", "f")
+	sink := new(fakeSink)
+	rep.SetMetricsSink(sink)
+
+	// lookups of whole placeholders that are retried
+	// without their modifiers or defaults are not misses
+	const input = "{fast|upper}{missing|upper}{fast:x}{missing:x|upper}"
+	if actual, expected := rep.ReplaceAll(input, ""), "FfX"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	var actual []observation
+	for _, o := range sink.observations {
+		actual = append(actual, observation{key: o.key, ok: o.ok})
+	}
+	expected := []observation{
+		{key: "fast", ok: true},
+		{key: "missing", ok: false},
+		{key: "fast", ok: true},
+		{key: "missing", ok: false},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected observations %v got %v", expected, actual)
+	}
+}
+
+func TestReplacerSlowThreshold(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("fast", "f")
+	rep.Map(func(key string) (string, bool) {
+		if key == "slow" {
+			time.Sleep(20 * time.Millisecond)
+			return "s", true
+		}
+		return "", false
+	})
+
+	var logs bytes.Buffer
+	rep.SetSlowThreshold(10*time.Millisecond, log.New(&logs, "", 0))
+	if actual := rep.ReplaceAll("{fast} {slow}", ""); actual != "f s" {
+		t.Errorf("Expected '%s' got '%s'", "f s", actual)
+	}
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "[WARNING] Slow placeholder resolution: {slow} took ") {
+		t.Errorf("Expected one warning about {slow}, got: %q", logs.String())
+	}
+
+	logs.Reset()
+	rep.SetSlowThreshold(0, log.New(&logs, "", 0))
+	rep.ReplaceAll("{slow}", "")
+	if logs.Len() != 0 {
+		t.Errorf("Expected no warnings without a threshold, got: %q", logs.String())
+	}
+}
@@ -0,0 +1,601 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Modifier transforms a resolved placeholder value. args are
+// what follows the name of the modifier in a placeholder:
+// either space-separated words, e.g. {key|name arg1 arg2}, or
+// colon-separated arguments, e.g. {key|name:arg1:arg2}, where
+// an argument in single quotes may contain colons and spaces.
+type Modifier func(val string, args []string) (string, error)
+
+// modifiers maps modifier names to their implementations.
+// modifiersMu guards it, as RegisterModifier may add to it
+// while other goroutines replace.
+var (
+	modifiers = map[string]Modifier{
+		"base":             modBase,
+		"base64":           modBase64,
+		"bytes":            modBytes,
+		"default":          modDefault,
+		"default_if_unset": modDefaultIfUnset,
+		"duration":         modDuration,
+		"escape":           modEscape,
+		"first":            modFirst,
+		"hex":              modHex,
+		"jsonstring":       modJSONString,
+		"last":             modLast,
+		"line":             modLine,
+		"lower":            modLower,
+		"map":              modMap,
+		"mask":             modMask,
+		"nfc":              modNFC,
+		"nfd":              modNFD,
+		"parsebytes":       modParseBytes,
+		"replace":          modReplace,
+		"required":         modRequired,
+		"shellquote":       modShellQuote,
+		"shortid":          modShortID,
+		"slug":             modSlug,
+		"split":            modSplit,
+		"trim":             modTrim,
+		"trimprefix":       modTrimPrefix,
+		"trimsuffix":       modTrimSuffix,
+		"unescape":         modUnescape,
+		"upper":            modUpper,
+		"urlencode":        modURLEncode,
+	}
+	modifiersMu sync.RWMutex
+)
+
+// RegisterModifier makes mod available to all replacers
+// under name, e.g. {key|name} or {key|name:arg}. It is
+// usually called from init. It fails if there already is
+// a modifier called name, or if name is empty or contains
+// characters that have a meaning in placeholders.
+func RegisterModifier(name string, mod Modifier) error {
+	if name == "" || strings.ContainsAny(name, " \t:|{}") {
+		return fmt.Errorf("invalid modifier name '%s'", name)
+	}
+	modifiersMu.Lock()
+	defer modifiersMu.Unlock()
+	if _, ok := modifiers[name]; ok {
+		return fmt.Errorf("modifier '%s' is already registered", name)
+	}
+	modifiers[name] = mod
+	return nil
+}
+
+// absentModifier supplies the value of key, which no
+// provider recognizes, from args, or fails.
+type absentModifier func(key string, args []string) (string, error)
+
+// absentModifiers maps the names of modifiers that apply
+// to unknown keys to what they do instead. Modifiers after
+// the first of these in a placeholder are applied to the
+// value it supplies; those before it are skipped.
+var absentModifiers = map[string]absentModifier{
+	"default":          absentDefault,
+	"default_if_unset": absentDefault,
+	"required":         absentRequired,
+}
+
+// applyModifier applies the modifier described by spec,
+// which is a modifier name optionally followed by
+// arguments, to val.
+func applyModifier(val, spec string) (string, error) {
+	name, args, err := parseModifier(spec)
+	if err != nil {
+		return val, err
+	}
+	modifiersMu.RLock()
+	mod, ok := modifiers[name]
+	modifiersMu.RUnlock()
+	if !ok {
+		return val, fmt.Errorf("unknown modifier '%s'", name)
+	}
+	return mod(val, args)
+}
+
+// parseModifier splits spec into the name of a modifier
+// and its arguments, which are either separated by spaces
+// or by colons.
+func parseModifier(spec string) (string, []string, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return "", nil, fmt.Errorf("empty modifier")
+	}
+	idx := strings.IndexAny(spec, " \t:")
+	if idx < 0 {
+		return spec, nil, nil
+	}
+	if spec[idx] != ':' {
+		return spec[:idx], strings.Fields(spec[idx:]), nil
+	}
+	args, err := splitColonArgs(spec[idx+1:])
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v", spec[:idx], err)
+	}
+	return spec[:idx], args, nil
+}
+
+// splitColonArgs splits s at colons which are
+// not in single quotes, and removes the quotes.
+func splitColonArgs(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			quoted = !quoted
+		case c == ':' && !quoted:
+			args = append(args, arg.String())
+			arg.Reset()
+		default:
+			arg.WriteByte(c)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in arguments '%s'", s)
+	}
+	return append(args, arg.String()), nil
+}
+
+// modEscape percent-encodes val so it can be
+// used as a segment of a URL path.
+func modEscape(val string, args []string) (string, error) {
+	return url.PathEscape(val), nil
+}
+
+// modUnescape decodes the percent-encoding of val.
+func modUnescape(val string, args []string) (string, error) {
+	return url.PathUnescape(val)
+}
+
+// modURLEncode encodes val so it can be used as a
+// query parameter or form value of a URL; spaces
+// become plus signs.
+func modURLEncode(val string, args []string) (string, error) {
+	return url.QueryEscape(val), nil
+}
+
+// modBase64 encodes val in standard base64. With the
+// argument "url", the URL-safe alphabet is used instead.
+func modBase64(val string, args []string) (string, error) {
+	enc := base64.StdEncoding
+	if len(args) > 0 {
+		switch args[0] {
+		case "std":
+		case "url":
+			enc = base64.URLEncoding
+		default:
+			return val, fmt.Errorf("base64: unknown encoding '%s'", args[0])
+		}
+	}
+	return enc.EncodeToString([]byte(val)), nil
+}
+
+// modHex encodes the bytes of val in lowercase hexadecimal.
+func modHex(val string, args []string) (string, error) {
+	return hex.EncodeToString([]byte(val)), nil
+}
+
+// modJSONString quotes val as a JSON string, escaping quotes,
+// backslashes and control characters, so that it can be put
+// into a JSON document as-is, e.g. {"name": {name|jsonstring}}.
+func modJSONString(val string, args []string) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(val); err != nil {
+		return val, fmt.Errorf("jsonstring: %v", err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// modShellQuote quotes val as a single word for a POSIX
+// shell: it is put in single quotes, within which nothing
+// is special, and each single quote in it is ended, escaped
+// and reopened as '\''. An empty value becomes ''.
+func modShellQuote(val string, args []string) (string, error) {
+	return "'" + strings.Replace(val, "'", `'\''`, -1) + "'", nil
+}
+
+// modNFC normalizes val to Unicode normalization form C, in
+// which characters are composed where possible, e.g. "e" with
+// a combining acute accent becomes "é". This makes names that
+// look the same, such as internationalized host names, equal.
+func modNFC(val string, args []string) (string, error) {
+	return norm.NFC.String(val), nil
+}
+
+// modNFD normalizes val to Unicode normalization form D, in
+// which characters are decomposed, e.g. "é" becomes "e" with
+// a combining acute accent, as some file systems store names.
+func modNFD(val string, args []string) (string, error) {
+	return norm.NFD.String(val), nil
+}
+
+// modRequired fails if val is empty. A key with the
+// required modifier which is not known to any provider
+// makes replacement fail as well. This is only useful
+// with methods that return errors, like ReplaceAllErr.
+func modRequired(val string, args []string) (string, error) {
+	if val == "" {
+		return val, fmt.Errorf("required value is empty")
+	}
+	return val, nil
+}
+
+// modDefaultIfUnset does nothing to the value of a known key,
+// even an empty one. What makes it useful is its absent
+// modifier, absentDefault.
+func modDefaultIfUnset(val string, args []string) (string, error) {
+	return val, nil
+}
+
+// absentDefault supplies the arguments of default_if_unset
+// or default, joined by spaces, as the value of an unknown
+// key, e.g. {env.X|default_if_unset foo} or {env.X|default:foo}.
+func absentDefault(key string, args []string) (string, error) {
+	return strings.Join(args, " "), nil
+}
+
+// modDefault substitutes the argument for val if val is
+// empty, e.g. {env.X|default:foo}. Unlike default_if_unset,
+// it also applies to keys that are known but empty.
+func modDefault(val string, args []string) (string, error) {
+	if val == "" {
+		return strings.Join(args, " "), nil
+	}
+	return val, nil
+}
+
+// modUpper converts val to upper case.
+func modUpper(val string, args []string) (string, error) {
+	return strings.ToUpper(val), nil
+}
+
+// modLower converts val to lower case.
+func modLower(val string, args []string) (string, error) {
+	return strings.ToLower(val), nil
+}
+
+// modTrim removes leading and trailing white space from
+// val, or, given an argument, the characters in it, e.g.
+// {key|trim:'/'}.
+func modTrim(val string, args []string) (string, error) {
+	if len(args) == 0 {
+		return strings.TrimSpace(val), nil
+	}
+	return strings.Trim(val, strings.Join(args, "")), nil
+}
+
+// modReplace replaces all occurrences of the first
+// argument in val with the second, e.g. {key|replace:a:b}.
+func modReplace(val string, args []string) (string, error) {
+	if len(args) != 2 || args[0] == "" {
+		return val, fmt.Errorf("replace: expected old and new strings, got %d arguments", len(args))
+	}
+	return strings.Replace(val, args[0], args[1], -1), nil
+}
+
+// modSplit splits val at each occurrence of the argument
+// into a list with one element per line, for modifiers
+// like first, last and line, e.g. {env.PATH|split:':'|first}.
+func modSplit(val string, args []string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return val, fmt.Errorf("split: expected separator")
+	}
+	return strings.Replace(val, args[0], "\n", -1), nil
+}
+
+// modFirst selects the first line of val, like {key|line 0}.
+func modFirst(val string, args []string) (string, error) {
+	return modLine(val, []string{"0"})
+}
+
+// modLast selects the last line of val, like {key|line -1}.
+func modLast(val string, args []string) (string, error) {
+	return modLine(val, []string{"-1"})
+}
+
+// absentRequired makes a required key that is unknown fail.
+func absentRequired(key string, args []string) (string, error) {
+	return "", fmt.Errorf("required key '%s' is unknown", key)
+}
+
+// modBase formats the integer val in the radix given by the
+// first argument, e.g. {n|base 16}. If the second argument is
+// "prefix", the conventional 0b, 0o or 0x prefix is added for
+// bases 2, 8 and 16. Values which are not integers are
+// returned unchanged.
+func modBase(val string, args []string) (string, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return val, fmt.Errorf("base: expected radix and optional 'prefix', got %d arguments", len(args))
+	}
+	radix, err := strconv.Atoi(args[0])
+	if err != nil || radix < 2 || radix > 36 {
+		return val, fmt.Errorf("base: invalid radix '%s'", args[0])
+	}
+	var withPrefix bool
+	if len(args) == 2 {
+		if args[1] != "prefix" {
+			return val, fmt.Errorf("base: unknown option '%s'", args[1])
+		}
+		withPrefix = true
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+	if err != nil {
+		return val, nil
+	}
+	// the magnitude is unsigned, since that of
+	// math.MinInt64 does not fit in an int64
+	sign, mag := "", uint64(n)
+	if n < 0 {
+		sign, mag = "-", -mag
+	}
+	prefix := ""
+	if withPrefix {
+		prefix = radixPrefixes[radix]
+	}
+	return sign + prefix + strconv.FormatUint(mag, radix), nil
+}
+
+// radixPrefixes are the prefixes of integer literals
+// written in the bases that have one.
+var radixPrefixes = map[int]string{2: "0b", 8: "0o", 16: "0x"}
+
+// modTrimPrefix removes the prefix given as argument
+// from val, if val starts with it.
+func modTrimPrefix(val string, args []string) (string, error) {
+	if len(args) == 0 {
+		return val, fmt.Errorf("trimprefix: missing prefix")
+	}
+	return strings.TrimPrefix(val, strings.Join(args, " ")), nil
+}
+
+// modTrimSuffix removes the suffix given as argument
+// from val, if val ends with it.
+func modTrimSuffix(val string, args []string) (string, error) {
+	if len(args) == 0 {
+		return val, fmt.Errorf("trimsuffix: missing suffix")
+	}
+	return strings.TrimSuffix(val, strings.Join(args, " ")), nil
+}
+
+// modLine selects a line of the multi-line val by the
+// zero-based index given as argument, e.g. {out|line 0}.
+// Negative indices count from the end, so -1 is the last
+// line. A trailing newline does not start another line,
+// and carriage returns at the ends of lines are removed.
+// If there is no such line, val is returned unchanged.
+func modLine(val string, args []string) (string, error) {
+	if len(args) != 1 {
+		return val, fmt.Errorf("line: expected index, got %d arguments", len(args))
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return val, fmt.Errorf("line: invalid index '%s'", args[0])
+	}
+	lines := strings.Split(strings.TrimSuffix(val, "\n"), "\n")
+	if n < 0 {
+		n += len(lines)
+	}
+	if n < 0 || n >= len(lines) {
+		return val, nil
+	}
+	return strings.TrimSuffix(lines[n], "\r"), nil
+}
+
+// modMap substitutes val using the key=value pairs given as
+// arguments, e.g. {env.ENV|map dev=Development prod=Production}.
+// If no key matches, val is returned unchanged. A value may
+// contain '=' but not spaces.
+func modMap(val string, args []string) (string, error) {
+	if len(args) == 0 {
+		return val, fmt.Errorf("map: expected key=value pairs")
+	}
+	mapped, matched := val, false
+	for _, arg := range args {
+		idx := strings.Index(arg, "=")
+		if idx < 0 {
+			return val, fmt.Errorf("map: malformed pair '%s', expected key=value", arg)
+		}
+		if !matched && arg[:idx] == val {
+			mapped, matched = arg[idx+1:], true
+		}
+	}
+	return mapped, nil
+}
+
+// modSlug turns val into an identifier that is safe
+// for file names and URLs, e.g. "My Great Site!" into
+// "my-great-site". Letters are lowercased; each run of
+// characters other than letters and digits, in any
+// script, becomes a single hyphen, and hyphens at the
+// ends are removed.
+func modSlug(val string, args []string) (string, error) {
+	var sb strings.Builder
+	sb.Grow(len(val))
+	hyphen := false
+	for _, r := range val {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			hyphen = sb.Len() > 0
+			continue
+		}
+		if hyphen {
+			sb.WriteByte('-')
+			hyphen = false
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String(), nil
+}
+
+// modMask replaces all but the last n characters of val
+// with asterisks, where n is the argument, e.g. {ip|mask 3}.
+// Values of at most n characters are returned unchanged.
+// This is for keeping logs readable, not for security.
+func modMask(val string, args []string) (string, error) {
+	if len(args) != 1 {
+		return val, fmt.Errorf("mask: expected number of characters to reveal, got %d arguments", len(args))
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return val, fmt.Errorf("mask: invalid number of characters '%s'", args[0])
+	}
+	runes := []rune(val)
+	if len(runes) <= n {
+		return val, nil
+	}
+	return strings.Repeat("*", len(runes)-n) + string(runes[len(runes)-n:]), nil
+}
+
+// modShortID returns a short, stable identifier for val:
+// the first n hexadecimal digits of its SHA-256 hash, where
+// n is the argument, between 1 and 64, or 8 if there is none,
+// e.g. {env.CONFIG|shortid 12}. It is not meant for security.
+func modShortID(val string, args []string) (string, error) {
+	n := 8
+	if len(args) > 1 {
+		return val, fmt.Errorf("shortid: expected length, got %d arguments", len(args))
+	}
+	if len(args) == 1 {
+		var err error
+		n, err = strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > sha256.Size*2 {
+			return val, fmt.Errorf("shortid: invalid length '%s'", args[0])
+		}
+	}
+	sum := sha256.Sum256([]byte(val))
+	return hex.EncodeToString(sum[:])[:n], nil
+}
+
+// modBytes formats val, a number of bytes, in a human-readable
+// form like "1 GiB". The units are IEC (powers of 1024) unless
+// the argument is "si", in which case they are powers of 1000.
+// Values which are not byte counts are returned unchanged.
+func modBytes(val string, args []string) (string, error) {
+	base, units := 1024.0, iecUnits
+	if len(args) > 0 {
+		switch args[0] {
+		case "iec":
+		case "si":
+			base, units = 1000.0, siUnits
+		default:
+			return val, fmt.Errorf("bytes: unknown unit system '%s'", args[0])
+		}
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(val), 10, 64)
+	if err != nil {
+		return val, nil
+	}
+	size, unit := float64(n), 0
+	for size >= base && unit < len(units)-1 {
+		size /= base
+		unit++
+	}
+	size = math.Round(size*10) / 10
+	return strconv.FormatFloat(size, 'f', -1, 64) + " " + units[unit], nil
+}
+
+// modParseBytes converts val, a human-readable size such as
+// "1GiB" or "10 MB", to a number of bytes. Both IEC and SI
+// units are understood. Values which cannot be parsed are
+// returned unchanged.
+func modParseBytes(val string, args []string) (string, error) {
+	n, err := humanize.ParseBytes(val)
+	if err != nil {
+		return val, nil
+	}
+	return strconv.FormatUint(n, 10), nil
+}
+
+// modDuration parses val as a duration, like "1.5s" or
+// "90m", and formats it as asked for by the argument: as a
+// number of the unit named by it, one of ns, us, ms, s, m
+// and h, e.g. {env.TIMEOUT|duration ms}, or, if it is
+// "human" or absent, like "1h30m". Values which are not
+// durations are returned unchanged.
+func modDuration(val string, args []string) (string, error) {
+	mode := "human"
+	if len(args) > 1 {
+		return val, fmt.Errorf("duration: expected unit, got %d arguments", len(args))
+	}
+	if len(args) == 1 {
+		mode = args[0]
+	}
+	unit, ok := durationUnits[mode]
+	if !ok && mode != "human" {
+		return val, fmt.Errorf("duration: unknown unit '%s'", mode)
+	}
+
+	d, err := time.ParseDuration(strings.TrimSpace(val))
+	if err != nil {
+		return val, nil
+	}
+	if mode != "human" {
+		return strconv.FormatFloat(float64(d)/float64(unit), 'f', -1, 64), nil
+	}
+	// drop zero minutes and seconds of whole hours or minutes
+	human := d.String()
+	if strings.HasSuffix(human, "m0s") {
+		human = strings.TrimSuffix(human, "0s")
+	}
+	if strings.HasSuffix(human, "h0m") {
+		human = strings.TrimSuffix(human, "0m")
+	}
+	return human, nil
+}
+
+// durationUnits maps the units of the duration
+// modifier to their lengths.
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// Units of byte sizes, in increasing order.
+var (
+	iecUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	siUnits  = []string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}
+)
@@ -0,0 +1,493 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// modifierTestCase is a placeholder input, the
+// expected output and whether an error is expected
+// from ReplaceAllErr.
+type modifierTestCase struct {
+	input     string
+	expected  string
+	shouldErr bool
+}
+
+// testModifiers runs ReplaceAllErr on each of tests using rep.
+func testModifiers(t *testing.T, rep Replacer, tests []modifierTestCase) {
+	t.Helper()
+	for i, tc := range tests {
+		actual, err := rep.ReplaceAllErr(tc.input, "")
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error for '%s', but got none", i, tc.input)
+		}
+		if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: Expected no error for '%s', but got: %v", i, tc.input, err)
+		}
+		if actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s' for '%s'", i, tc.expected, actual, tc.input)
+		}
+	}
+}
+
+func TestModifierEscape(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("path", "/a b/c%20d")
+	rep.Set("encoded", "a%20b%2Fc%3Fd%26e")
+	rep.Set("reserved", "a?b&c#d")
+	rep.Set("bad", "100%")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{encoded|unescape}", expected: "a b/c?d&e"},
+		{input: "{path|unescape}", expected: "/a b/c d"},
+		{input: "{reserved|escape}", expected: "a%3Fb&c%23d"},
+		{input: "{path|escape}", expected: "%2Fa%20b%2Fc%2520d"},
+		{input: "{encoded|unescape|escape}", expected: "a%20b%2Fc%3Fd&e"},
+		{input: "{bad|unescape}", expected: "100%", shouldErr: true},
+		{input: "{path|nope}", expected: "/a b/c%20d", shouldErr: true},
+	})
+
+	// ReplaceAll leaves the value unchanged on error
+	if actual := rep.ReplaceAll("{bad|unescape}", ""); actual != "100%" {
+		t.Errorf("Expected '%s' got '%s'", "100%", actual)
+	}
+}
+
+func TestModifierFullKeyMatch(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("a|unescape", "literal")
+	rep.Set("a", "%41")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{a|unescape}", expected: "literal"},
+		{input: "{a|escape}", expected: "%2541"},
+		{input: "{unknown|escape}", expected: ""},
+	})
+}
+
+func TestModifierBase(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("n", "15")
+	rep.Set("neg", "-10")
+	rep.Set("min", "-9223372036854775808")
+	rep.Set("float", "1.5")
+	rep.Set("text", "abc")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{n|base 2}", expected: "1111"},
+		{input: "{n|base 8}", expected: "17"},
+		{input: "{n|base 16}", expected: "f"},
+		{input: "{n|base 2 prefix}", expected: "0b1111"},
+		{input: "{n|base 8 prefix}", expected: "0o17"},
+		{input: "{n|base 16 prefix}", expected: "0xf"},
+		{input: "{n|base 36 prefix}", expected: "f"},
+		{input: "{neg|base 16 prefix}", expected: "-0xa"},
+		{input: "{min|base 16}", expected: "-8000000000000000"},
+		{input: "{min|base 10}", expected: "-9223372036854775808"},
+		{input: "{float|base 16}", expected: "1.5"},
+		{input: "{text|base 2}", expected: "abc"},
+		{input: "{n|base}", expected: "15", shouldErr: true},
+		{input: "{n|base 1}", expected: "15", shouldErr: true},
+		{input: "{n|base 16 upper}", expected: "15", shouldErr: true},
+	})
+}
+
+func TestModifierTrimAffix(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("host", "www.example.local")
+	rep.Set("plain", "example.com")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{host|trimprefix www.}", expected: "example.local"},
+		{input: "{host|trimsuffix .local}", expected: "www.example"},
+		{input: "{host|trimprefix www.|trimsuffix .local}", expected: "example"},
+		{input: "{plain|trimprefix www.}", expected: "example.com"},
+		{input: "{plain|trimsuffix .local}", expected: "example.com"},
+		{input: "{host|trimprefix}", expected: "www.example.local", shouldErr: true},
+		{input: "{host|trimsuffix}", expected: "www.example.local", shouldErr: true},
+	})
+}
+
+func TestModifierBytes(t *testing.T) {
+	rep := NewReplacer()
+	for key, val := range map[string]string{
+		"b":      "512",
+		"kib":    "1024",
+		"mib":    "1572864",
+		"gib":    "1073741824",
+		"si":     "1500000",
+		"text":   "lots",
+		"hgib":   "1GiB",
+		"hmib":   "1.5 MiB",
+		"hkb":    "10 kB",
+		"hraw":   "2048",
+		"hwrong": "12 parsecs",
+	} {
+		rep.Set(key, val)
+	}
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{b|bytes}", expected: "512 B"},
+		{input: "{kib|bytes}", expected: "1 KiB"},
+		{input: "{mib|bytes}", expected: "1.5 MiB"},
+		{input: "{gib|bytes}", expected: "1 GiB"},
+		{input: "{gib|bytes iec}", expected: "1 GiB"},
+		{input: "{si|bytes si}", expected: "1.5 MB"},
+		{input: "{gib|bytes si}", expected: "1.1 GB"},
+		{input: "{text|bytes}", expected: "lots"},
+		{input: "{gib|bytes binary}", expected: "1073741824", shouldErr: true},
+		{input: "{hgib|parsebytes}", expected: "1073741824"},
+		{input: "{hmib|parsebytes}", expected: "1572864"},
+		{input: "{hkb|parsebytes}", expected: "10000"},
+		{input: "{hraw|parsebytes}", expected: "2048"},
+		{input: "{hwrong|parsebytes}", expected: "12 parsecs"},
+		{input: "{gib|bytes|parsebytes}", expected: "1073741824"},
+	})
+}
+
+func TestModifierDuration(t *testing.T) {
+	rep := NewReplacer()
+	for key, val := range map[string]string{
+		"timeout": "1.5s",
+		"long":    "90m",
+		"hour":    "1h",
+		"mixed":   "1h2m3s",
+		"short":   "250ms",
+		"zero":    "0s",
+		"text":    "forever",
+	} {
+		rep.Set(key, val)
+	}
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{timeout|duration ms}", expected: "1500"},
+		{input: "{timeout|duration:ms}", expected: "1500"},
+		{input: "{timeout|duration s}", expected: "1.5"},
+		{input: "{timeout|duration us}", expected: "1500000"},
+		{input: "{timeout|duration ns}", expected: "1500000000"},
+		{input: "{long|duration h}", expected: "1.5"},
+		{input: "{long|duration m}", expected: "90"},
+		{input: "{long|duration human}", expected: "1h30m"},
+		{input: "{long|duration}", expected: "1h30m"},
+		{input: "{hour|duration}", expected: "1h"},
+		{input: "{mixed|duration}", expected: "1h2m3s"},
+		{input: "{short|duration}", expected: "250ms"},
+		{input: "{zero|duration}", expected: "0s"},
+		{input: "{text|duration ms}", expected: "forever"},
+		{input: "{timeout|duration days}", expected: "1.5s", shouldErr: true},
+		{input: "{timeout|duration ms s}", expected: "1.5s", shouldErr: true},
+	})
+}
+
+func TestModifierEncodings(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("quote", `say "hi"`)
+	rep.Set("path", `C:\temp\new`)
+	rep.Set("query", "a b&c=d/é")
+	rep.Set("ctrl", "tab\tline\nbell\x07")
+	rep.Set("unicode", "héllo, 世界 <&>")
+	rep.Set("blank", "")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{quote|base64}", expected: "c2F5ICJoaSI="},
+		{input: "{query|base64}", expected: "YSBiJmM9ZC/DqQ=="},
+		{input: "{query|base64 url}", expected: "YSBiJmM9ZC_DqQ=="},
+		{input: "{query|base64 std}", expected: "YSBiJmM9ZC/DqQ=="},
+		{input: "{query|base64 raw}", expected: "a b&c=d/é", shouldErr: true},
+		{input: "{query|urlencode}", expected: "a+b%26c%3Dd%2F%C3%A9"},
+		{input: "{quote|urlencode}", expected: "say+%22hi%22"},
+		{input: "{quote|hex}", expected: "7361792022686922"},
+		{input: "{unicode|hex}", expected: "68c3a96c6c6f2c20e4b896e7958c203c263e"},
+		{input: "{quote|jsonstring}", expected: `"say \"hi\""`},
+		{input: "{path|jsonstring}", expected: `"C:\\temp\\new"`},
+		{input: "{ctrl|jsonstring}", expected: `"tab\tline\nbell\u0007"`},
+		{input: "{unicode|jsonstring}", expected: `"héllo, 世界 <&>"`},
+		{input: "{blank|jsonstring}", expected: `""`},
+		{input: "{blank|base64}", expected: ""},
+	})
+}
+
+func TestModifierShellQuote(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("plain", "file.txt")
+	rep.Set("spaces", "my file.txt")
+	rep.Set("quotes", `it's a "test"`)
+	rep.Set("special", "$(rm -rf /); `id` && echo $HOME | cat > x \\ *")
+	rep.Set("blank", "")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{plain|shellquote}", expected: "'file.txt'"},
+		{input: "{spaces|shellquote}", expected: "'my file.txt'"},
+		{input: "{quotes|shellquote}", expected: `'it'\''s a "test"'`},
+		{input: "{special|shellquote}", expected: "'$(rm -rf /); `id` && echo $HOME | cat > x \\ *'"},
+		{input: "{blank|shellquote}", expected: "''"},
+	})
+}
+
+func TestModifierNormalization(t *testing.T) {
+	const (
+		composed   = "caf\u00e9.example"  // é as one code point
+		decomposed = "cafe\u0301.example" // e and a combining acute accent
+	)
+	rep := NewReplacer()
+	rep.Set("composed", composed)
+	rep.Set("decomposed", decomposed)
+	rep.Set("ascii", "example.com")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{decomposed|nfc}", expected: composed},
+		{input: "{composed|nfc}", expected: composed},
+		{input: "{composed|nfd}", expected: decomposed},
+		{input: "{decomposed|nfd}", expected: decomposed},
+		{input: "{composed|nfd|nfc}", expected: composed},
+		{input: "{ascii|nfc}", expected: "example.com"},
+		{input: "{ascii|nfd}", expected: "example.com"},
+	})
+}
+
+func TestModifierRequired(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("present", "value")
+	rep.Set("empty", "")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{present|required}", expected: "value"},
+		{input: "{present|required|escape}", expected: "value"},
+		{input: "{empty|required}", expected: "", shouldErr: true},
+		{input: "{unknown|required}", expected: "", shouldErr: true},
+		{input: "{unknown|escape|required}", expected: "", shouldErr: true},
+	})
+
+	for _, tc := range []struct {
+		input   string
+		message string
+	}{
+		{input: "{empty|required}", message: "{empty|required}: required value is empty"},
+		{input: "a {unknown|required} b", message: "{unknown|required}: required key 'unknown' is unknown"},
+	} {
+		_, err := rep.ReplaceAllErr(tc.input, "")
+		if err == nil || err.Error() != tc.message {
+			t.Errorf("Expected error '%s' got '%v'", tc.message, err)
+		}
+	}
+
+	// without the error path, required has no effect
+	if actual := rep.ReplaceAll("{unknown|required}", "-"); actual != "-" {
+		t.Errorf("Expected '%s' got '%s'", "-", actual)
+	}
+}
+
+func TestModifierLine(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("out", "first\nsecond\nthird\n")
+	rep.Set("crlf", "one\r\ntwo\r\n")
+	rep.Set("single", "only")
+	rep.Set("blank", "a\n\nc")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{out|line 0}", expected: "first"},
+		{input: "{out|line 2}", expected: "third"},
+		{input: "{out|line -1}", expected: "third"},
+		{input: "{out|line -3}", expected: "first"},
+		{input: "{out|line 3}", expected: "first\nsecond\nthird\n"},
+		{input: "{out|line -4}", expected: "first\nsecond\nthird\n"},
+		{input: "{crlf|line 1}", expected: "two"},
+		{input: "{single|line 0}", expected: "only"},
+		{input: "{blank|line 1}", expected: ""},
+		{input: "{blank|line 2}", expected: "c"},
+		{input: "{out|line}", expected: "first\nsecond\nthird\n", shouldErr: true},
+		{input: "{out|line first}", expected: "first\nsecond\nthird\n", shouldErr: true},
+	})
+}
+
+func TestModifierDefaultIfUnset(t *testing.T) {
+	os.Setenv("CADDY_DEFAULT_IF_UNSET", "")
+	defer os.Unsetenv("CADDY_DEFAULT_IF_UNSET")
+	os.Unsetenv("CADDY_REALLY_UNSET")
+
+	rep := NewReplacer()
+	rep.Set("set", "value")
+	rep.Set("empty", "")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{unset|default_if_unset foo}", expected: "foo"},
+		{input: "{unset|default_if_unset two words}", expected: "two words"},
+		{input: "{unset|default_if_unset}", expected: ""},
+		{input: "[{empty|default_if_unset foo}]", expected: "[]"},
+		{input: "{set|default_if_unset foo}", expected: "value"},
+		{input: "{env.CADDY_REALLY_UNSET|default_if_unset a b}", expected: "a b"},
+		{input: "[{env.CADDY_DEFAULT_IF_UNSET|default_if_unset foo}]", expected: "[]"},
+		{input: "{unset|default_if_unset a/b|escape}", expected: "a%2Fb"},
+		{input: "{unset|escape|default_if_unset a/b}", expected: "a/b"},
+		{input: "{unset|default_if_unset x|required}", expected: "x"},
+		{input: "{unset|required|default_if_unset x}", expected: "", shouldErr: true},
+	})
+
+	// ReplaceAll substitutes the empty value only when there is no default
+	if actual := rep.ReplaceAll("{empty|default_if_unset foo}|{unset|default_if_unset foo}", "-"); actual != "-|foo" {
+		t.Errorf("Expected '%s' got '%s'", "-|foo", actual)
+	}
+}
+
+func TestModifierMap(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("env", "prod")
+	rep.Set("other", "qa")
+	rep.Set("empty", "")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{env|map dev=Development prod=Production}", expected: "Production"},
+		{input: "{other|map dev=Development prod=Production}", expected: "qa"},
+		{input: "{env|map prod=a=b}", expected: "a=b"},
+		{input: "{env|map prod= dev=x}", expected: ""},
+		{input: "{env|map prod=first prod=second}", expected: "first"},
+		{input: "[{empty|map =none}]", expected: "[none]"},
+		{input: "{env|map dev=Development prod}", expected: "prod", shouldErr: true},
+		{input: "{env|map}", expected: "prod", shouldErr: true},
+	})
+}
+
+func TestModifierSlug(t *testing.T) {
+	rep := NewReplacer()
+	for key, val := range map[string]string{
+		"title":   "My Great Site!",
+		"punct":   "--Hello,   World...  (2019)--",
+		"unicode": "Café Olé — Über Straße",
+		"cjk":     "日本語 サイト",
+		"symbols": "!!!",
+		"plain":   "already-a-slug",
+	} {
+		rep.Set(key, val)
+	}
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{title|slug}", expected: "my-great-site"},
+		{input: "{punct|slug}", expected: "hello-world-2019"},
+		{input: "{unicode|slug}", expected: "café-olé-über-straße"},
+		{input: "{cjk|slug}", expected: "日本語-サイト"},
+		{input: "[{symbols|slug}]", expected: "[]"},
+		{input: "{plain|slug}", expected: "already-a-slug"},
+	})
+}
+
+func TestModifierMask(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("ip", "203.0.113.42")
+	rep.Set("short", "ab")
+	rep.Set("unicode", "пароль")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{ip|mask 3}", expected: "*********.42"},
+		{input: "{ip|mask 0}", expected: "************"},
+		{input: "{ip|mask 12}", expected: "203.0.113.42"},
+		{input: "{short|mask 3}", expected: "ab"},
+		{input: "{unicode|mask 2}", expected: "****ль"},
+		{input: "{ip|mask}", expected: "203.0.113.42", shouldErr: true},
+		{input: "{ip|mask -1}", expected: "203.0.113.42", shouldErr: true},
+		{input: "{ip|mask three}", expected: "203.0.113.42", shouldErr: true},
+	})
+}
+
+func TestModifierPipeline(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("host", "  Web1.Example.COM ")
+	rep.Set("path", "/usr/local/bin:/usr/bin:/bin")
+	rep.Set("empty", "")
+	rep.Set("a|b", "literal key")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{host|trim|lower}", expected: "web1.example.com"},
+		{input: "{host|trim|upper}", expected: "WEB1.EXAMPLE.COM"},
+		{input: "{host|trim|lower|replace:example:test}", expected: "web1.test.com"},
+		{input: "{path|split:':'|first}", expected: "/usr/local/bin"},
+		{input: "{path|split:':'|last}", expected: "/bin"},
+		{input: "{path|split:':'|line 1}", expected: "/usr/bin"},
+		{input: "{path|replace:':':' '}", expected: "/usr/local/bin /usr/bin /bin"},
+		{input: "{path|trim:'/'}", expected: "usr/local/bin:/usr/bin:/bin"},
+		{input: "{empty|default:none}", expected: "none"},
+		{input: "{host|trim|default:none}", expected: "Web1.Example.COM"},
+		{input: "{unknown|default:'a:b'|upper}", expected: "A:B"},
+		{input: "{a|b}", expected: "literal key"},
+		{input: "{host|trim|shout|lower}", expected: "web1.example.com", shouldErr: true},
+		{input: "{path|replace:a}", expected: "/usr/local/bin:/usr/bin:/bin", shouldErr: true},
+		{input: "{path|split:'}", expected: "/usr/local/bin:/usr/bin:/bin", shouldErr: true},
+	})
+}
+
+func TestRegisterModifier(t *testing.T) {
+	err := RegisterModifier("test_reverse", func(val string, args []string) (string, error) {
+		runes := []rune(val)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return strings.Repeat(string(runes), len(args)+1), nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer func() {
+		modifiersMu.Lock()
+		delete(modifiers, "test_reverse")
+		modifiersMu.Unlock()
+	}()
+
+	rep := NewReplacer()
+	rep.Set("word", "caddy")
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{word|test_reverse}", expected: "yddac"},
+		{input: "{word|test_reverse|upper}", expected: "YDDAC"},
+		{input: "{word|test_reverse:x}", expected: "yddacyddac"},
+	})
+
+	for _, name := range []string{"test_reverse", "upper", "", "has space", "a:b", "a|b"} {
+		if err := RegisterModifier(name, modUpper); err == nil {
+			t.Errorf("Expected error registering modifier '%s', but got none", name)
+		}
+	}
+}
+
+func TestModifierShortID(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("config", "listen :443")
+	rep.Set("other", "listen :80")
+	rep.Set("empty", "")
+
+	testModifiers(t, rep, []modifierTestCase{
+		{input: "{empty|shortid}", expected: "e3b0c442"},
+		{input: "{empty|shortid 12}", expected: "e3b0c44298fc"},
+		{input: "{empty|shortid:4}", expected: "e3b0"},
+		{input: "{config|shortid 0}", expected: "listen :443", shouldErr: true},
+		{input: "{config|shortid 65}", expected: "listen :443", shouldErr: true},
+		{input: "{config|shortid 8 9}", expected: "listen :443", shouldErr: true},
+	})
+
+	id := rep.ReplaceAll("{config|shortid 10}", "")
+	if len(id) != 10 || strings.Trim(id, "0123456789abcdef") != "" {
+		t.Errorf("Expected 10 hex characters, got '%s'", id)
+	}
+	if again := rep.ReplaceAll("{config|shortid 10}", ""); again != id {
+		t.Errorf("Expected the same id for the same value, got '%s' and '%s'", id, again)
+	}
+	if other := rep.ReplaceAll("{other|shortid 10}", ""); other == id {
+		t.Errorf("Expected different ids for different values, got '%s' for both", id)
+	}
+	if full := rep.ReplaceAll("{config|shortid 64}", ""); len(full) != 64 || full[:10] != id {
+		t.Errorf("Expected full hash starting with '%s', got '%s'", id, full)
+	}
+}
@@ -0,0 +1,520 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FromStruct returns a ReplacementFunc which resolves keys
+// of the form prefix.Field from the exported fields of the
+// struct v (or pointer to one). A field is named by its
+// `repl` struct tag, or by its Go name if it has none; a
+// tag of "-" hides the field. Fields of nested structs are
+// reached with dotted paths, like {cfg.TLS.CertFile}.
+// Values which are not strings are formatted with
+// fmt.Sprint. Fields are read at replace-time, so changes
+// to v are reflected.
+func FromStruct(prefix string, v interface{}) ReplacementFunc {
+	if prefix != "" {
+		prefix += "."
+	}
+	return func(key string) (string, bool) {
+		if !strings.HasPrefix(key, prefix) {
+			return "", false
+		}
+		val := reflect.ValueOf(v)
+		for _, name := range strings.Split(key[len(prefix):], ".") {
+			for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+				if val.IsNil() {
+					return "", false
+				}
+				val = val.Elem()
+			}
+			if val.Kind() != reflect.Struct {
+				return "", false
+			}
+			var ok bool
+			val, ok = structField(val, name)
+			if !ok {
+				return "", false
+			}
+		}
+		for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+			if val.IsNil() {
+				return "", false
+			}
+			val = val.Elem()
+		}
+		if val.Kind() == reflect.String {
+			return val.String(), true
+		}
+		return fmt.Sprint(val.Interface()), true
+	}
+}
+
+// FromForm returns a ReplacementFunc which resolves keys of
+// the form prefix.field from values, such as the parsed form
+// of a request body. The value of a field with several values
+// is the first one; keys of the form prefix.all.field join
+// all of them with commas. Fields without values are not
+// recognized.
+func FromForm(prefix string, values url.Values) ReplacementFunc {
+	if prefix != "" {
+		prefix += "."
+	}
+	allPrefix := prefix + "all."
+	return func(key string) (string, bool) {
+		if strings.HasPrefix(key, allPrefix) {
+			vals := values[key[len(allPrefix):]]
+			if len(vals) == 0 {
+				return "", false
+			}
+			return strings.Join(vals, ","), true
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return "", false
+		}
+		vals := values[key[len(prefix):]]
+		if len(vals) == 0 {
+			return "", false
+		}
+		return vals[0], true
+	}
+}
+
+// structField returns the exported field of the struct
+// val which is named name, either by its repl tag or, if
+// it has no tag, by its Go name.
+func structField(val reflect.Value, name string) (reflect.Value, bool) {
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		fieldName := field.Name
+		if tag, ok := field.Tag.Lookup("repl"); ok {
+			fieldName = tag
+		}
+		if fieldName == name && fieldName != "-" {
+			return val.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// CredentialStore looks up secrets in a platform secret
+// store, such as the macOS keychain, the Windows
+// Credential Manager or the Secret Service on Linux.
+type CredentialStore interface {
+	Lookup(service, account string) (secret string, err error)
+}
+
+// FromCredentialStore returns a ReplacementFunc which
+// resolves keys of the form keychain.service.account from
+// store. The service name ends at the first dot; the
+// account name may contain dots. Secrets which cannot be
+// looked up are not recognized.
+func FromCredentialStore(store CredentialStore) ReplacementFunc {
+	const prefix = "keychain."
+	return func(key string) (string, bool) {
+		if !strings.HasPrefix(key, prefix) {
+			return "", false
+		}
+		parts := strings.SplitN(key[len(prefix):], ".", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", false
+		}
+		secret, err := store.Lookup(parts[0], parts[1])
+		if err != nil {
+			return "", false
+		}
+		return secret, true
+	}
+}
+
+// DirectoryLookup looks up attributes of entries in a
+// directory service, such as LDAP.
+type DirectoryLookup interface {
+	Lookup(dn, attribute string) (value string, err error)
+}
+
+// FromDirectory returns a ReplacementFunc which resolves
+// keys of the form ldap.dn.attribute from dir, e.g.
+// {ldap.cn=admin,dc=example,dc=com.mail}. The attribute
+// name follows the last dot. Attributes which cannot be
+// looked up are not recognized.
+func FromDirectory(dir DirectoryLookup) ReplacementFunc {
+	const prefix = "ldap."
+	return func(key string) (string, bool) {
+		if !strings.HasPrefix(key, prefix) {
+			return "", false
+		}
+		key = key[len(prefix):]
+		idx := strings.LastIndex(key, ".")
+		if idx <= 0 || idx == len(key)-1 {
+			return "", false
+		}
+		val, err := dir.Lookup(key[:idx], key[idx+1:])
+		if err != nil {
+			return "", false
+		}
+		return val, true
+	}
+}
+
+// envReplacement returns the value of the environment variable
+// name, even if it is empty. Unset variables are not recognized,
+// and neither are names of the form NAME:fallback, e.g. from
+// {env.PORT:80}: the value of env.NAME is then looked up from all
+// providers, so that another provider of env.* placeholders, like
+// one of LoadDotEnvLayers, can supply it, and only if none knows
+// it is fallback used, whatever the empty value of the render.
+func envReplacement(name string) (string, bool) {
+	if strings.Contains(name, defaultSep) {
+		return "", false
+	}
+	return os.LookupEnv(name)
+}
+
+// typedEnv resolves keys of the form env.NAME:type, e.g.
+// {env.PORT:int}, whose colon is at idx, to the value of env.NAME
+// if it is of type, which is one of int, uint, float, bool and
+// duration, and fails if it is not, so that misconfiguration is
+// caught early. The value is looked up from overlay and all
+// providers, like that of a key with a default, and returned
+// as-is; if none of them knows env.NAME, the key is not
+// recognized.
+func (r *replacer) typedEnv(overlay map[string]string, key string, idx int) (string, bool, error) {
+	typ := key[idx+len(defaultSep):]
+	val, ok, err := r.lookupIn(overlay, key[:idx])
+	if err != nil || !ok {
+		return "", false, err
+	}
+	if err := envTypes[typ](val); err != nil {
+		return "", false, fmt.Errorf("environment variable %s is not of type %s: '%s'", key[len(envPrefix):idx], typ, val)
+	}
+	return val, true, nil
+}
+
+// isTypedEnv reports whether key, whose first
+// colon is at idx, is of the form env.NAME:type.
+func isTypedEnv(key string, idx int) bool {
+	if !strings.HasPrefix(key, envPrefix) || idx < len(envPrefix) {
+		return false
+	}
+	_, ok := envTypes[key[idx+len(defaultSep):]]
+	return ok
+}
+
+// envPrefix starts the keys of environment variables.
+const envPrefix = "env."
+
+// envTypes maps the types of typed environment
+// variables to functions that check values.
+var envTypes = map[string]func(string) error{
+	"int": func(s string) error {
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err
+	},
+	"uint": func(s string) error {
+		_, err := strconv.ParseUint(s, 10, 64)
+		return err
+	},
+	"float": func(s string) error {
+		_, err := strconv.ParseFloat(s, 64)
+		return err
+	},
+	"bool": func(s string) error {
+		_, err := strconv.ParseBool(s)
+		return err
+	},
+	"duration": func(s string) error {
+		_, err := time.ParseDuration(s)
+		return err
+	},
+}
+
+// envJSONReplacement resolves name, which is the name of an
+// environment variable containing JSON, a dot, and a JSON
+// pointer into that document, e.g. CONFIG./database/host.
+// Without a pointer, the whole document is returned. The
+// variable is only parsed again when its value changes.
+func envJSONReplacement(name string) (string, bool) {
+	var pointer string
+	if idx := strings.Index(name, "/"); idx >= 0 {
+		name, pointer = strings.TrimSuffix(name[:idx], "."), name[idx:]
+	}
+	raw, ok := os.LookupEnv(name)
+	if !ok {
+		return "", false
+	}
+
+	var doc interface{}
+	if cached, ok := envJSONCache.Load(name); ok && cached.(envJSONDoc).raw == raw {
+		doc = cached.(envJSONDoc).doc
+	} else {
+		if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+			return "", false
+		}
+		envJSONCache.Store(name, envJSONDoc{raw: raw, doc: doc})
+	}
+
+	val, ok := jsonPointer(doc, pointer)
+	if !ok {
+		return "", false
+	}
+	if s, ok := val.(string); ok {
+		return s, true
+	}
+	b, err := json.Marshal(val)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// envJSONDoc is a parsed environment variable.
+type envJSONDoc struct {
+	raw string
+	doc interface{}
+}
+
+// envJSONCache maps environment variable names
+// to their last parsed value, as envJSONDoc.
+var envJSONCache sync.Map
+
+// jsonPointer evaluates the RFC 6901 JSON pointer
+// in the decoded JSON document doc.
+func jsonPointer(doc interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return doc, true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = unescape.Replace(token)
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if doc, ok = node[token]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			doc = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// systemdCredential returns the contents of the credential
+// called name that systemd passed to the service through the
+// directory in $CREDENTIALS_DIRECTORY, without trailing
+// newlines. The credential is not recognized if the variable
+// is not set or if there is no such credential.
+func systemdCredential(name string) (string, bool) {
+	dir, ok := os.LookupEnv("CREDENTIALS_DIRECTORY")
+	if !ok || dir == "" {
+		return "", false
+	}
+	// credential names are plain file names
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimRight(string(b), "\r\n"), true
+}
+
+// fileReplacement returns the contents of the file at path,
+// without trailing newlines, e.g. for {file./run/secrets/token}.
+// Directories, files larger than maxFileReplacementSize and
+// files that cannot be read are not recognized.
+func fileReplacement(path string) (string, bool) {
+	if path == "" {
+		return "", false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() || info.Size() > maxFileReplacementSize {
+		return "", false
+	}
+	// the file may grow while it is read
+	b, err := ioutil.ReadAll(io.LimitReader(f, maxFileReplacementSize+1))
+	if err != nil || int64(len(b)) > maxFileReplacementSize {
+		return "", false
+	}
+	return strings.TrimRight(string(b), "\r\n"), true
+}
+
+// maxFileReplacementSize is the size of the largest
+// file whose contents a file. placeholder expands to.
+var maxFileReplacementSize int64 = 1 << 20
+
+// RunningConfig is the configuration of running servers,
+// such as an Instance.
+type RunningConfig interface {
+	// ServerListenAddrs maps the names of servers
+	// to the addresses they listen on.
+	ServerListenAddrs() map[string][]string
+}
+
+// FromRunningConfig returns a ReplacementFunc which resolves
+// keys of the form caddy.servers.name.listen, for example
+// {caddy.servers.srv0.listen}, to the addresses that the server
+// called name in cfg listens on, separated by commas. cfg is
+// read at replace-time. Servers that are not in cfg, and all
+// keys if cfg is nil, are not recognized.
+func FromRunningConfig(cfg RunningConfig) ReplacementFunc {
+	const prefix, suffix = "caddy.servers.", ".listen"
+	return func(key string) (string, bool) {
+		if cfg == nil || !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, suffix) ||
+			len(key) <= len(prefix)+len(suffix) {
+			return "", false
+		}
+		addrs, ok := cfg.ServerListenAddrs()[key[len(prefix):len(key)-len(suffix)]]
+		if !ok {
+			return "", false
+		}
+		return strings.Join(addrs, ","), true
+	}
+}
+
+// relativePath returns the path of a target relative to a
+// base directory, given as "base target", as filepath.Rel
+// does, e.g. for {path.rel /srv /srv/site/index.html}. Paths
+// that cannot be made relative, such as one absolute and one
+// relative path, are not recognized, and neither are paths
+// with modifiers, so that they are applied afterwards.
+func relativePath(args string) (string, bool) {
+	if strings.Contains(args, modSep) {
+		return "", false
+	}
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return "", false
+	}
+	rel, err := filepath.Rel(fields[0], fields[1])
+	if err != nil {
+		return "", false
+	}
+	return rel, true
+}
+
+// randReplacement resolves keys of the form rand.kind:n to a
+// fresh random value from crypto/rand: rand.hex:n for n random
+// bytes in hexadecimal, e.g. {rand.hex:16}, or rand.int:n for
+// an integer in [0, n), e.g. {rand.int:1000}. An invalid n is
+// an error rather than a miss, since the key:fallback syntax
+// would otherwise render n itself. Other keys, and keys with
+// modifiers, which are applied afterwards, are not recognized.
+func randReplacement(key string) (string, bool, error) {
+	const randPrefix = "rand."
+	if !strings.HasPrefix(key, randPrefix) || strings.Contains(key, modSep) {
+		return "", false, nil
+	}
+	parts := strings.SplitN(key[len(randPrefix):], defaultSep, 2)
+	if len(parts) != 2 {
+		return "", false, nil
+	}
+	switch parts[0] {
+	case "hex":
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 || n > maxRandBytes {
+			return "", false, fmt.Errorf("%s: number of bytes must be from 1 to %d, got '%s'", key, maxRandBytes, parts[1])
+		}
+		b := make([]byte, n)
+		if _, err := rand.Read(b); err != nil {
+			return "", false, fmt.Errorf("%s: %v", key, err)
+		}
+		return hex.EncodeToString(b), true, nil
+	case "int":
+		max, ok := new(big.Int).SetString(parts[1], 10)
+		if !ok || max.Sign() <= 0 {
+			return "", false, fmt.Errorf("%s: bound must be a positive integer, got '%s'", key, parts[1])
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", false, fmt.Errorf("%s: %v", key, err)
+		}
+		return n.String(), true, nil
+	}
+	return "", false, nil
+}
+
+// maxRandBytes is the most random bytes
+// a rand.hex placeholder may ask for.
+const maxRandBytes = 1024
+
+// LoadStdinProvider returns a provider which resolves {stdin}
+// to the contents of in, which is normally os.Stdin, without
+// trailing newlines. Since reading standard input consumes it,
+// the provider must be mapped explicitly, with MapErr. in is
+// read to the end the first time {stdin} is looked up, and
+// later lookups return the same contents; if reading fails,
+// every lookup fails with the same error.
+func LoadStdinProvider(in io.Reader) ReplacementErrFunc {
+	var (
+		once     sync.Once
+		contents string
+		readErr  error
+	)
+	return func(key string) (string, bool, error) {
+		if key != "stdin" {
+			return "", false, nil
+		}
+		once.Do(func() {
+			b, err := ioutil.ReadAll(in)
+			if err != nil {
+				readErr = fmt.Errorf("reading stdin: %v", err)
+				return
+			}
+			contents = strings.TrimRight(string(b), "\r\n")
+		})
+		if readErr != nil {
+			return "", false, readErr
+		}
+		return contents, true, nil
+	}
+}
@@ -0,0 +1,564 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// providerTestCase is a key and the value that a
+// provider is expected to return for it.
+type providerTestCase struct {
+	key      string
+	expected string
+	ok       bool
+}
+
+// testProvider checks the results of fn for each of tests.
+func testProvider(t *testing.T, fn ReplacementFunc, tests []providerTestCase) {
+	t.Helper()
+	for i, tc := range tests {
+		val, ok := fn(tc.key)
+		if ok != tc.ok || val != tc.expected {
+			t.Errorf("Test %d: Expected ('%s', %t) for key '%s' got ('%s', %t)",
+				i, tc.expected, tc.ok, tc.key, val, ok)
+		}
+	}
+}
+
+func TestFromStruct(t *testing.T) {
+	type tlsConfig struct {
+		CertFile string `repl:"cert"`
+		Enabled  bool
+	}
+	type config struct {
+		ListenAddr string `repl:"ListenAddr"`
+		Port       int    `repl:"port"`
+		Timeout    time.Duration
+		TLS        tlsConfig
+		Backup     *tlsConfig
+		Hidden     string `repl:"-"`
+		secret     string
+	}
+	cfg := &config{
+		ListenAddr: "0.0.0.0",
+		Port:       8080,
+		Timeout:    30 * time.Second,
+		TLS:        tlsConfig{CertFile: "/etc/cert.pem", Enabled: true},
+		Hidden:     "hidden",
+		secret:     "secret",
+	}
+
+	fn := FromStruct("cfg", cfg)
+	testProvider(t, fn, []providerTestCase{
+		{key: "cfg.ListenAddr", expected: "0.0.0.0", ok: true},
+		{key: "cfg.port", expected: "8080", ok: true},
+		{key: "cfg.Timeout", expected: "30s", ok: true},
+		{key: "cfg.TLS.cert", expected: "/etc/cert.pem", ok: true},
+		{key: "cfg.TLS.Enabled", expected: "true", ok: true},
+		{key: "cfg.TLS.CertFile", ok: false}, // tag takes precedence
+		{key: "cfg.Backup.cert", ok: false},  // nil pointer
+		{key: "cfg.Port", ok: false},
+		{key: "cfg.Hidden", ok: false},
+		{key: "cfg.secret", ok: false},
+		{key: "cfg.ListenAddr.More", ok: false},
+		{key: "other.ListenAddr", ok: false},
+	})
+
+	// changes to the struct are reflected
+	cfg.Backup = &tlsConfig{CertFile: "/etc/backup.pem"}
+	testProvider(t, fn, []providerTestCase{
+		{key: "cfg.Backup.cert", expected: "/etc/backup.pem", ok: true},
+	})
+
+	rep := NewReplacer()
+	rep.Map(fn)
+	if actual := rep.ReplaceAll("{cfg.ListenAddr}:{cfg.port}", ""); actual != "0.0.0.0:8080" {
+		t.Errorf("Expected '%s' got '%s'", "0.0.0.0:8080", actual)
+	}
+}
+
+type fakeCredentialStore map[string]string
+
+func (s fakeCredentialStore) Lookup(service, account string) (string, error) {
+	secret, ok := s[service+"/"+account]
+	if !ok {
+		return "", fmt.Errorf("no secret for %s/%s", service, account)
+	}
+	return secret, nil
+}
+
+func TestFromForm(t *testing.T) {
+	form, err := url.ParseQuery("name=Jane+Doe&tag=a&tag=b&tag=c&empty=&all.x=literal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testProvider(t, FromForm("form", form), []providerTestCase{
+		{key: "form.name", expected: "Jane Doe", ok: true},
+		{key: "form.tag", expected: "a", ok: true},
+		{key: "form.all.tag", expected: "a,b,c", ok: true},
+		{key: "form.all.name", expected: "Jane Doe", ok: true},
+		{key: "form.empty", expected: "", ok: true},
+		{key: "form.missing", ok: false},
+		{key: "form.all.missing", ok: false},
+		{key: "name", ok: false},
+	})
+
+	form.Add("late", "added")
+	testProvider(t, FromForm("", form), []providerTestCase{
+		{key: "name", expected: "Jane Doe", ok: true},
+		{key: "late", expected: "added", ok: true},
+		{key: "all.tag", expected: "a,b,c", ok: true},
+	})
+}
+
+func TestFromCredentialStore(t *testing.T) {
+	fn := FromCredentialStore(fakeCredentialStore{
+		"db/admin":              "hunter2",
+		"smtp/mail@example.com": "s3cret",
+		"api/empty":             "",
+	})
+
+	testProvider(t, fn, []providerTestCase{
+		{key: "keychain.db.admin", expected: "hunter2", ok: true},
+		{key: "keychain.smtp.mail@example.com", expected: "s3cret", ok: true},
+		{key: "keychain.api.empty", expected: "", ok: true},
+		{key: "keychain.db.nobody", ok: false},
+		{key: "keychain.db", ok: false},
+		{key: "keychain..admin", ok: false},
+		{key: "env.db.admin", ok: false},
+	})
+}
+
+type fakeDirectory map[string]map[string]string
+
+func (d fakeDirectory) Lookup(dn, attribute string) (string, error) {
+	val, ok := d[dn][attribute]
+	if !ok {
+		return "", fmt.Errorf("no attribute %s for %s", attribute, dn)
+	}
+	return val, nil
+}
+
+func TestFromDirectory(t *testing.T) {
+	fn := FromDirectory(fakeDirectory{
+		"cn=admin": {
+			"mail": "admin@example.com",
+		},
+		"cn=john.doe,dc=example,dc=com": {
+			"uid":  "1001",
+			"mail": "john@example.com",
+		},
+	})
+
+	testProvider(t, fn, []providerTestCase{
+		{key: "ldap.cn=admin.mail", expected: "admin@example.com", ok: true},
+		{key: "ldap.cn=john.doe,dc=example,dc=com.uid", expected: "1001", ok: true},
+		{key: "ldap.cn=admin.phone", ok: false},
+		{key: "ldap.cn=nobody.mail", ok: false},
+		{key: "ldap.mail", ok: false},
+		{key: "ldap.cn=admin.", ok: false},
+		{key: "keychain.cn=admin.mail", ok: false},
+	})
+}
+
+func TestEnvJSONReplacement(t *testing.T) {
+	os.Setenv("CADDY_REPLACER_JSON", `{
+		"database": {"host": "db.local", "port": 5432, "tls": true},
+		"hosts": ["a.example.com", "b.example.com"],
+		"a/b": {"~c": "escaped"}
+	}`)
+	os.Setenv("CADDY_REPLACER_BADJSON", `{"database":`)
+	defer os.Unsetenv("CADDY_REPLACER_JSON")
+	defer os.Unsetenv("CADDY_REPLACER_BADJSON")
+
+	fn := NewReplacer().(*replacer).get
+	testProvider(t, fn, []providerTestCase{
+		{key: "envjson.CADDY_REPLACER_JSON./database/host", expected: "db.local", ok: true},
+		{key: "envjson.CADDY_REPLACER_JSON./database/port", expected: "5432", ok: true},
+		{key: "envjson.CADDY_REPLACER_JSON./database/tls", expected: "true", ok: true},
+		{key: "envjson.CADDY_REPLACER_JSON./hosts/1", expected: "b.example.com", ok: true},
+		{key: "envjson.CADDY_REPLACER_JSON./hosts", expected: `["a.example.com","b.example.com"]`, ok: true},
+		{key: "envjson.CADDY_REPLACER_JSON./a~1b/~0c", expected: "escaped", ok: true},
+		{key: "envjson.CADDY_REPLACER_JSON./hosts/2", ok: false},
+		{key: "envjson.CADDY_REPLACER_JSON./database/user", ok: false},
+		{key: "envjson.CADDY_REPLACER_JSON./database/host/x", ok: false},
+		{key: "envjson.CADDY_REPLACER_BADJSON./database", ok: false},
+		{key: "envjson.CADDY_REPLACER_UNSET./database", ok: false},
+	})
+
+	// a changed variable is parsed again
+	os.Setenv("CADDY_REPLACER_JSON", `{"database": {"host": "db.remote"}}`)
+	testProvider(t, fn, []providerTestCase{
+		{key: "envjson.CADDY_REPLACER_JSON./database/host", expected: "db.remote", ok: true},
+	})
+}
+
+// countingReader counts the calls to Read of
+// the wrapped reader.
+type countingReader struct {
+	r     io.Reader
+	reads int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.reads++
+	return c.r.Read(p)
+}
+
+// failingReader fails every read.
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("broken pipe")
+}
+
+func TestLoadStdinProvider(t *testing.T) {
+	in := &countingReader{r: bytes.NewBufferString("piped value\n")}
+	rep := NewReplacer()
+	rep.MapErr(LoadStdinProvider(in))
+
+	if in.reads != 0 {
+		t.Errorf("Expected stdin not to be read before it is needed, got %d reads", in.reads)
+	}
+	for i := 0; i < 2; i++ {
+		actual, err := rep.ReplaceAllErr("[{stdin}] [{stdin}] {other}", "-")
+		if expected := "[piped value] [piped value] -"; actual != expected || err != nil {
+			t.Errorf("Render %d: Expected '%s' got '%s' (err=%v)", i, expected, actual, err)
+		}
+	}
+	reads := in.reads
+	rep.ReplaceAll("{stdin}", "")
+	if in.reads != reads {
+		t.Errorf("Expected stdin to be read once, got %d more reads", in.reads-reads)
+	}
+
+	rep = NewReplacer()
+	rep.MapErr(LoadStdinProvider(failingReader{}))
+	if _, err := rep.ReplaceAllErr("{stdin}", ""); err == nil || err.Error() != "{stdin}: reading stdin: broken pipe" {
+		t.Errorf("Expected read error, got: %v", err)
+	}
+}
+
+func TestFileReplacement(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caddy_file_repl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "secrets", "db"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{
+		"token": "abc123\n",
+		"empty": "",
+		"large": "0123456789abcdef!",
+		filepath.Join("secrets", "db", "password"): "s3cret\r\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldMax := maxFileReplacementSize
+	defer func() { maxFileReplacementSize = oldMax }()
+	maxFileReplacementSize = 16
+
+	testProvider(t, globalDefaultReplacements, []providerTestCase{
+		{key: "file." + filepath.Join(dir, "token"), expected: "abc123", ok: true},
+		{key: "file." + filepath.Join(dir, "empty"), expected: "", ok: true},
+		{key: "file." + filepath.Join(dir, "secrets", "db", "password"), expected: "s3cret", ok: true},
+		{key: "file." + filepath.Join(dir, "missing"), ok: false},
+		{key: "file." + filepath.Join(dir, "secrets"), ok: false},
+		{key: "file." + filepath.Join(dir, "large"), ok: false},
+		{key: "file.", ok: false},
+	})
+
+	// a default applies if the file cannot be read
+	rep := NewReplacer()
+	input := "{file." + filepath.Join(dir, "missing") + ":none}"
+	if actual, expected := rep.ReplaceAll(input, "-"), "none"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestRelativePath(t *testing.T) {
+	base := filepath.Join(string(filepath.Separator)+"base", "dir")
+	testProvider(t, globalDefaultReplacements, []providerTestCase{
+		{key: "path.rel " + base + " " + filepath.Join(base, "sub", "file"), expected: filepath.Join("sub", "file"), ok: true},
+		{key: "path.rel " + base + " " + base, expected: ".", ok: true},
+		{key: "path.rel " + base + " " + filepath.Join(base, "..", "other"), expected: filepath.Join("..", "other"), ok: true},
+		{key: "path.rel " + base + " " + filepath.Join(string(filepath.Separator)+"etc", "hosts"), expected: filepath.Join("..", "..", "etc", "hosts"), ok: true},
+		{key: "path.rel " + base + " relative/file", ok: false},
+		{key: "path.rel " + base, ok: false},
+		{key: "path.rel a b c", ok: false},
+	})
+
+	rep := NewReplacer()
+	input := "{path.rel " + base + " " + filepath.Join(base, "sub", "file") + "|upper}"
+	if actual, expected := rep.ReplaceAll(input, "-"), strings.ToUpper(filepath.Join("sub", "file")); actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestRandomReplacements(t *testing.T) {
+	rep := NewReplacer()
+	uuidPattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	hexPattern := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		ids := strings.Split(rep.ReplaceAll("{uuid} {uuid}", "-"), " ")
+		for _, id := range ids {
+			if !uuidPattern.MatchString(id) {
+				t.Errorf("Expected a version 4 UUID, got '%s'", id)
+			}
+			if seen[id] {
+				t.Errorf("Expected fresh UUIDs, got '%s' twice", id)
+			}
+			seen[id] = true
+		}
+
+		val := rep.ReplaceAll("{rand.hex:16}", "-")
+		if !hexPattern.MatchString(val) {
+			t.Errorf("Expected 16 random bytes in hex, got '%s'", val)
+		}
+		if seen[val] {
+			t.Errorf("Expected fresh random bytes, got '%s' twice", val)
+		}
+		seen[val] = true
+
+		val = rep.ReplaceAll("{rand.int:1000}", "-")
+		if n, err := strconv.Atoi(val); err != nil || n < 0 || n >= 1000 {
+			t.Errorf("Expected an integer in [0, 1000), got '%s'", val)
+		}
+	}
+	if actual := rep.ReplaceAll("{rand.int:1}", "-"); actual != "0" {
+		t.Errorf("Expected '%s' got '%s'", "0", actual)
+	}
+
+	if actual := rep.ReplaceAll("{rand.hex:4|upper}", "-"); !regexp.MustCompile(`^[0-9A-F]{8}$`).MatchString(actual) {
+		t.Errorf("Expected 4 random bytes in upper-case hex, got '%s'", actual)
+	}
+
+	// invalid arguments are errors, not the
+	// fallback of a key:fallback placeholder
+	for i, input := range []string{
+		"{rand.hex:0}",
+		"{rand.hex:x}",
+		"{rand.hex:100000}",
+		"{rand.int:0}",
+		"{rand.int:-5}",
+		"{rand.int:abc}",
+	} {
+		actual, err := rep.ReplaceAllErr(input, "-")
+		if err == nil {
+			t.Errorf("Test %d: Expected error for %s, got '%s'", i, input, actual)
+		}
+		if actual != "-" {
+			t.Errorf("Test %d: Expected '%s' for %s got '%s'", i, "-", input, actual)
+		}
+	}
+
+	// other keys are not recognized
+	for i, key := range []string{"rand.int", "rand.float:1", "random.hex:4"} {
+		if val, ok, err := randReplacement(key); ok || err != nil {
+			t.Errorf("Test %d: Expected key '%s' not to be recognized, got ('%s', %t, %v)", i, key, val, ok, err)
+		}
+	}
+}
+
+// fakeRunningConfig is a RunningConfig backed by a map.
+type fakeRunningConfig map[string][]string
+
+func (c fakeRunningConfig) ServerListenAddrs() map[string][]string { return c }
+
+func TestFromRunningConfig(t *testing.T) {
+	cfg := fakeRunningConfig{
+		"srv0":     {"[::]:443", "[::]:80"},
+		"srv1":     {"127.0.0.1:2019"},
+		"api.prod": {"10.0.0.1:8080"},
+		"idle":     nil,
+	}
+	testProvider(t, FromRunningConfig(cfg), []providerTestCase{
+		{key: "caddy.servers.srv0.listen", expected: "[::]:443,[::]:80", ok: true},
+		{key: "caddy.servers.srv1.listen", expected: "127.0.0.1:2019", ok: true},
+		{key: "caddy.servers.api.prod.listen", expected: "10.0.0.1:8080", ok: true},
+		{key: "caddy.servers.idle.listen", expected: "", ok: true},
+		{key: "caddy.servers.srv2.listen", ok: false},
+		{key: "caddy.servers.srv0", ok: false},
+		{key: "caddy.servers..listen", ok: false},
+		{key: "caddy.servers.listen", ok: false},
+	})
+
+	testProvider(t, FromRunningConfig(nil), []providerTestCase{
+		{key: "caddy.servers.srv0.listen", ok: false},
+	})
+
+	// an instance is a running config
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Cannot listen: %v", err)
+	}
+	defer ln.Close()
+	inst := new(Instance)
+	inst.SaveServer(nil, ln)
+	testProvider(t, FromRunningConfig(inst), []providerTestCase{
+		{key: "caddy.servers.srv0.listen", expected: ln.Addr().String(), ok: true},
+		{key: "caddy.servers.srv1.listen", ok: false},
+	})
+	var noInst *Instance
+	testProvider(t, FromRunningConfig(noInst), []providerTestCase{
+		{key: "caddy.servers.srv0.listen", ok: false},
+	})
+}
+
+func TestTypedEnvReplacement(t *testing.T) {
+	for name, val := range map[string]string{
+		"CADDY_TYPED_PORT":    "8080",
+		"CADDY_TYPED_NEG":     "-1",
+		"CADDY_TYPED_DEBUG":   "true",
+		"CADDY_TYPED_RATIO":   "0.75",
+		"CADDY_TYPED_TIMEOUT": "1m30s",
+		"CADDY_TYPED_WRONG":   "eighty",
+	} {
+		os.Setenv(name, val)
+		defer os.Unsetenv(name)
+	}
+	os.Unsetenv("CADDY_TYPED_UNSET")
+
+	rep := NewReplacer()
+	// types are checked on the value of whichever
+	// provider knows the variable
+	rep.Map(mapProvider{
+		"env.CADDY_TYPED_MAPPED":     "9000",
+		"env.CADDY_TYPED_MAPPED_BAD": "ninety",
+	}.Replace)
+	for i, tc := range []struct {
+		input     string
+		expected  string
+		shouldErr bool
+	}{
+		{input: "{env.CADDY_TYPED_PORT:int}", expected: "8080"},
+		{input: "{env.CADDY_TYPED_PORT:uint}", expected: "8080"},
+		{input: "{env.CADDY_TYPED_NEG:int}", expected: "-1"},
+		{input: "{env.CADDY_TYPED_DEBUG:bool}", expected: "true"},
+		{input: "{env.CADDY_TYPED_RATIO:float}", expected: "0.75"},
+		{input: "{env.CADDY_TYPED_TIMEOUT:duration}", expected: "1m30s"},
+		{input: "{env.CADDY_TYPED_PORT:int|base 16}", expected: "1f90"},
+		{input: "{env.CADDY_TYPED_NEG:uint}", shouldErr: true},
+		{input: "{env.CADDY_TYPED_WRONG:int}", shouldErr: true},
+		{input: "{env.CADDY_TYPED_PORT:bool}", shouldErr: true},
+		{input: "{env.CADDY_TYPED_RATIO:int}", shouldErr: true},
+		{input: "{env.CADDY_TYPED_UNSET:int}", expected: "-"},
+		{input: "{env.CADDY_TYPED_MAPPED:int}", expected: "9000"},
+		{input: "{env.CADDY_TYPED_MAPPED_BAD:int}", shouldErr: true},
+		{input: "{env.CADDY_TYPED_WRONG:other}", expected: "eighty"},
+		{input: "{env.CADDY_TYPED_UNSET:other}", expected: "other"},
+	} {
+		actual, err := rep.ReplaceAllErr(tc.input, "-")
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: Expected error, got '%s'", i, actual)
+			}
+			if actual != "-" {
+				t.Errorf("Test %d: Expected '%s' got '%s'", i, "-", actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: Unexpected error: %v", i, err)
+		}
+		if actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	_, err := rep.ReplaceAllErr("{env.CADDY_TYPED_WRONG:int}", "")
+	if expected := "{env.CADDY_TYPED_WRONG:int}: environment variable CADDY_TYPED_WRONG is not of type int: 'eighty'"; err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s', got: %v", expected, err)
+	}
+}
+
+func TestEnvReplacementFallback(t *testing.T) {
+	os.Setenv("CADDY_ENV_SET", "8443")
+	os.Setenv("CADDY_ENV_EMPTY", "")
+	os.Unsetenv("CADDY_ENV_UNSET")
+	defer os.Unsetenv("CADDY_ENV_SET")
+	defer os.Unsetenv("CADDY_ENV_EMPTY")
+
+	testProvider(t, globalDefaultReplacements, []providerTestCase{
+		{key: "env.CADDY_ENV_SET", expected: "8443", ok: true},
+		{key: "env.CADDY_ENV_EMPTY", expected: "", ok: true},
+		{key: "env.CADDY_ENV_UNSET", ok: false},
+		// fallbacks are applied after all providers are asked
+		{key: "env.CADDY_ENV_SET:443", ok: false},
+		{key: "env.CADDY_ENV_UNSET:443", ok: false},
+	})
+
+	// the fallback does not depend on the empty value
+	rep := NewReplacer()
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "{env.CADDY_ENV_SET:443}", expected: "8443"},
+		{input: "{env.CADDY_ENV_UNSET:443}", expected: "443"},
+		{input: "{env.CADDY_ENV_UNSET:host:port}", expected: "host:port"},
+		{input: "[{env.CADDY_ENV_UNSET:}]", expected: "[-]"},
+		{input: "{env.CADDY_ENV_UNSET}", expected: "-"},
+		{input: "{env.CADDY_ENV_EMPTY:443}", expected: "-"},
+		{input: "{env.CADDY_ENV_EMPTY}", expected: "-"},
+		{input: "{env.CADDY_ENV_UNSET:a b|upper}", expected: "A B"},
+	} {
+		if actual := rep.ReplaceAll(tc.input, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// other providers of env.* placeholders are asked
+	// before the fallback is used
+	rep.Map(mapProvider{"env.CADDY_ENV_UNSET": "9000"}.Replace)
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "{env.CADDY_ENV_UNSET:443}", expected: "9000"},
+		{input: "{env.CADDY_ENV_SET:443}", expected: "8443"},
+		{input: "{env.CADDY_ENV_OTHER:443}", expected: "443"},
+	} {
+		if actual := rep.ReplaceAll(tc.input, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// and fallbacks of unknown variables wait for the last stage
+	const staged = "{env.CADDY_ENV_OTHER:x} {later:x}"
+	if actual := rep.ReplaceKnown(staged, "-"); actual != staged {
+		t.Errorf("Expected '%s' got '%s'", staged, actual)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSystemdCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caddy_sdcreds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, contents := range map[string]string{
+		"dbpass": "s3cret\n",
+		"token":  "abc\r\n\n",
+		"empty":  "",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(filepath.Dir(dir), "caddy_sdcreds_outside"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filepath.Join(filepath.Dir(dir), "caddy_sdcreds_outside"))
+
+	oldDir, wasSet := os.LookupEnv("CREDENTIALS_DIRECTORY")
+	defer func() {
+		if wasSet {
+			os.Setenv("CREDENTIALS_DIRECTORY", oldDir)
+		} else {
+			os.Unsetenv("CREDENTIALS_DIRECTORY")
+		}
+	}()
+
+	os.Setenv("CREDENTIALS_DIRECTORY", dir)
+	testProvider(t, globalDefaultReplacements, []providerTestCase{
+		{key: "sdcreds.dbpass", expected: "s3cret", ok: true},
+		{key: "sdcreds.token", expected: "abc", ok: true},
+		{key: "sdcreds.empty", expected: "", ok: true},
+		{key: "sdcreds.missing", ok: false},
+		{key: "sdcreds.../caddy_sdcreds_outside", ok: false},
+		{key: "sdcreds.", ok: false},
+	})
+
+	os.Unsetenv("CREDENTIALS_DIRECTORY")
+	testProvider(t, globalDefaultReplacements, []providerTestCase{
+		{key: "sdcreds.dbpass", ok: false},
+	})
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// ReplaceStream is like ReplaceAll, but it reads the input from
+// in and writes the output to w as it goes, so large templates
+// need not be held in memory. A placeholder that is split across
+// reads is held back until its closing delimiter arrives; if the
+// stream ends first, it is written as-is, like an unterminated
+// placeholder passed to ReplaceAll. So is a placeholder that grows
+// longer than maxStreamPlaceholderLen without being terminated, so
+// that a stray opener cannot make the rest of the stream be held
+// in memory. Only errors from reading and writing are returned.
+func (r *replacer) ReplaceStream(in io.Reader, w io.Writer, empty string) error {
+	opener, closer := r.delims()
+	sc := streamScanner{opener: opener, closer: closer}
+	chunk := make([]byte, streamChunkSize)
+	var pending []byte
+	var out bytes.Buffer
+	for {
+		n, readErr := in.Read(chunk)
+		pending = append(pending, chunk[:n]...)
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		eof := readErr == io.EOF
+
+		cut := len(pending)
+		if !eof {
+			cut = sc.cut(bytesView(pending))
+		}
+		if cut > 0 {
+			out.Reset()
+			r.replaceTo(&out, bytesView(pending[:cut]), empty, replaceOpts{copyKeys: true})
+			if _, err := w.Write(out.Bytes()); err != nil {
+				return err
+			}
+			pending = append(pending[:0], pending[cut:]...)
+		}
+		if eof {
+			return nil
+		}
+	}
+}
+
+// streamChunkSize is how much ReplaceStream reads at once.
+const streamChunkSize = 32 * 1024
+
+// maxStreamPlaceholderLen is the longest a placeholder read
+// by ReplaceStream may grow without being terminated before its
+// opener is taken to be literal text.
+const maxStreamPlaceholderLen = 8 * 1024
+
+// streamScanner finds where the input of ReplaceStream can be
+// cut. It remembers how much of a placeholder that is held back
+// has been searched for its closer already, so that each read
+// only searches what is new.
+type streamScanner struct {
+	opener, closer string
+
+	// searched is how much of the input, which starts
+	// with an unterminated placeholder if it is not 0,
+	// has been searched for the closer
+	searched int
+}
+
+// cut returns the length of the part of s, the input read
+// so far, that can be replaced without knowing what follows:
+// it ends before the first placeholder that is not terminated
+// yet, or before a delimiter or escape that may be incomplete.
+// What is not cut off must be passed to the next call,
+// followed by what is read next.
+func (sc *streamScanner) cut(s string) int {
+	opener, closer := sc.opener, sc.closer
+	longest := len(opener)
+	if len(closer) > longest {
+		longest = len(closer)
+	}
+
+	// once there is no closer after some position,
+	// there is none after any later position either
+	noCloser := false
+
+	i := 0
+	if sc.searched > 0 {
+		from := sc.searched - len(closer) + 1
+		if from < len(opener) {
+			from = len(opener)
+		}
+		sc.searched = 0
+		if end := strings.Index(s[from:], closer); end >= 0 {
+			i = from + end + len(closer)
+		} else if len(s) > maxStreamPlaceholderLen {
+			// too long to be a placeholder: the
+			// opener is literal, like an unmatched one
+			i, noCloser = len(opener), true
+		} else {
+			sc.searched = len(s)
+			return 0
+		}
+	}
+
+	for ; i < len(s); i++ {
+		if s[i] == escapeChar[0] {
+			if len(s)-i-1 < longest {
+				return i
+			}
+			if n := escapedLen(s[i+1:], opener, closer); n > 0 {
+				i += n
+				continue
+			}
+		}
+		if !strings.HasPrefix(s[i:], opener) {
+			if strings.HasPrefix(opener, s[i:]) {
+				return i
+			}
+			continue
+		}
+		end := -1
+		if !noCloser {
+			end = strings.Index(s[i+len(opener):], closer)
+		}
+		if end < 0 {
+			noCloser = true
+			if len(s)-i > maxStreamPlaceholderLen {
+				i += len(opener) - 1
+				continue
+			}
+			sc.searched = len(s) - i
+			return i
+		}
+		i += len(opener) + end + len(closer) - 1
+	}
+	return len(s)
+}
@@ -0,0 +1,176 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package caddy
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// chunkedReader returns its chunks one read at a time.
+type chunkedReader struct {
+	chunks []string
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if len(cr.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, cr.chunks[0])
+	cr.chunks[0] = cr.chunks[0][n:]
+	if cr.chunks[0] == "" {
+		cr.chunks = cr.chunks[1:]
+	}
+	return n, nil
+}
+
+func TestReplacerReplaceStream(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Set("port", "443")
+
+	for i, tc := range []struct {
+		chunks   []string
+		expected string
+	}{
+		{chunks: []string{"https://{ho", "st}:{port}/"}, expected: "https://example.com:443/"},
+		{chunks: []string{"https://{", "host}/"}, expected: "https://example.com/"},
+		{chunks: []string{"https://{host", "}/"}, expected: "https://example.com/"},
+		{chunks: []string{"{host}", "{port}"}, expected: "example.com443"},
+		{chunks: []string{"{host|up", "per}", ":{port", ":80}"}, expected: "EXAMPLE.COM:443"},
+		{chunks: []string{"[{unknown", "}]"}, expected: "[-]"},
+		{chunks: []string{"\\", "{host\\}"}, expected: "{host}"},
+		{chunks: []string{"\\\\", "{host}"}, expected: "\\example.com"},
+		{chunks: []string{"{host} {unterminated", " to the end"}, expected: "example.com {unterminated to the end"},
+		{chunks: []string{"plain ", "text"}, expected: "plain text"},
+	} {
+		var out bytes.Buffer
+		if err := rep.ReplaceStream(&chunkedReader{chunks: tc.chunks}, &out, "-"); err != nil {
+			t.Errorf("Test %d: Unexpected error: %v", i, err)
+		}
+		if actual := out.String(); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+
+		// every possible boundary gives the same output
+		out.Reset()
+		input := strings.Join(tc.chunks, "")
+		if err := rep.ReplaceStream(iotest.OneByteReader(strings.NewReader(input)), &out, "-"); err != nil {
+			t.Errorf("Test %d: Unexpected error: %v", i, err)
+		}
+		if actual, expected := out.String(), rep.ReplaceAll(input, "-"); actual != expected {
+			t.Errorf("Test %d: Expected '%s' byte by byte got '%s'", i, expected, actual)
+		}
+	}
+
+	var out bytes.Buffer
+	in := io.MultiReader(strings.NewReader("{host} "), failingReader{})
+	if err := rep.ReplaceStream(in, &out, "-"); err == nil || err.Error() != "broken pipe" {
+		t.Errorf("Expected read error, got: %v", err)
+	}
+}
+
+func TestReplacerReplaceStreamDelims(t *testing.T) {
+	rep := NewReplacerWithDelims("[[", "]]")
+	rep.Set("host", "example.com")
+
+	var out bytes.Buffer
+	input := "https://[[host]]/{host}/[[missing]]"
+	if err := rep.ReplaceStream(iotest.OneByteReader(strings.NewReader(input)), &out, "-"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if actual, expected := out.String(), "https://example.com/{host}/-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+// lagWriter records how far its writes lag
+// behind the reads of a lagReader.
+type lagWriter struct {
+	in     *lagReader
+	maxLag int
+	buf    bytes.Buffer
+}
+
+func (lw *lagWriter) Write(p []byte) (int, error) {
+	if lag := lw.in.read - lw.buf.Len(); lag > lw.maxLag {
+		lw.maxLag = lag
+	}
+	return lw.buf.Write(p)
+}
+
+// lagReader counts the bytes read from r.
+type lagReader struct {
+	r    io.Reader
+	read int
+}
+
+func (lr *lagReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.read += n
+	return n, err
+}
+
+func TestReplacerReplaceStreamLongPlaceholder(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+
+	// a placeholder split into many reads is searched for
+	// its closer only once, and still resolves
+	long := "{host:" + strings.Repeat("x", maxStreamPlaceholderLen/2) + "}"
+	var out bytes.Buffer
+	if err := rep.ReplaceStream(iotest.OneByteReader(strings.NewReader(long+"/")), &out, "-"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if actual, expected := out.String(), "example.com/"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	// an opener that is never terminated is literal once
+	// it is too long to be a placeholder, rather than
+	// holding back the rest of the stream
+	stray := "{" + strings.Repeat("a", 4*maxStreamPlaceholderLen)
+	in := &lagReader{r: strings.NewReader(stray + "} \\{host\\} {host}")}
+	w := &lagWriter{in: in}
+	if err := rep.ReplaceStream(in, w, "-"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if actual, expected := w.buf.String(), stray+"} {host} example.com"; actual != expected {
+		t.Errorf("Expected the stray opener to be literal, got %d bytes: '%.40s...'", len(actual), actual)
+	}
+	if limit := maxStreamPlaceholderLen + streamChunkSize; w.maxLag > limit {
+		t.Errorf("Expected output to lag behind input by at most %d bytes, got %d", limit, w.maxLag)
+	}
+}
+
+func TestStreamScannerResume(t *testing.T) {
+	sc := streamScanner{opener: "{", closer: "}"}
+	for i, tc := range []struct {
+		input    string
+		expected int
+	}{
+		{input: "ab{cd", expected: 2},
+		{input: "{cdef", expected: 0},
+		{input: "{cdef}gh", expected: 8},
+		{input: "{x", expected: 0},
+		{input: "{x}{y", expected: 3},
+	} {
+		if actual := sc.cut(tc.input); actual != tc.expected {
+			t.Errorf("Test %d: Expected cut at %d got %d", i, tc.expected, actual)
+		}
+	}
+}
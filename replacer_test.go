@@ -0,0 +1,1627 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestReplacerNew(t *testing.T) {
+	var tc = NewReplacer()
+
+	rep, ok := tc.(*replacer)
+	if !ok {
+		t.Fatalf("Expected type of replacer %T got %T", &replacer{}, tc)
+	}
+	if len(rep.providers) != 2 {
+		t.Fatalf("Expected providers length '%v' got length '%v'", 2, len(rep.providers))
+	}
+
+	// test if default global replacements are added
+	hostname, _ := os.Hostname()
+	wd, _ := os.Getwd()
+	os.Setenv("CADDY_REPLACER_TEST", "envtest")
+	defer os.Setenv("CADDY_REPLACER_TEST", "")
+
+	for _, tc := range []struct {
+		variable string
+		value    string
+	}{
+		{
+			variable: "system.hostname",
+			value:    hostname,
+		},
+		{
+			variable: "system.slash",
+			value:    string(filepath.Separator),
+		},
+		{
+			variable: "system.os",
+			value:    runtime.GOOS,
+		},
+		{
+			variable: "system.arch",
+			value:    runtime.GOARCH,
+		},
+		{
+			variable: "system.wd",
+			value:    wd,
+		},
+		{
+			variable: "system.pid",
+			value:    strconv.Itoa(os.Getpid()),
+		},
+		{
+			variable: "system.ppid",
+			value:    strconv.Itoa(os.Getppid()),
+		},
+		{
+			variable: "env.CADDY_REPLACER_TEST",
+			value:    "envtest",
+		},
+	} {
+		if val, ok := rep.get(tc.variable); !ok || val != tc.value {
+			t.Errorf("Expected value '%s' for key '%s' got '%s' (ok=%t)", tc.value, tc.variable, val, ok)
+		}
+	}
+
+	val, ok := rep.get("system.num_cpu")
+	if numCPU, err := strconv.Atoi(val); !ok || err != nil || numCPU != runtime.NumCPU() {
+		t.Errorf("Expected system.num_cpu to be %d got '%s' (ok=%t)", runtime.NumCPU(), val, ok)
+	}
+}
+
+func TestReplacerSet(t *testing.T) {
+	rep := NewReplacer()
+
+	rep.Set("test1", "val1")
+	rep.Set("system.os", "overridden")
+	rep.Set("", "empty key")
+
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "{test1}", expected: "val1"},
+		{input: "{system.os}", expected: "overridden"},
+		{input: "{}", expected: "empty key"},
+	} {
+		if actual := rep.ReplaceAll(tc.input, ""); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+}
+
+func TestReplacerDelete(t *testing.T) {
+	rep := NewReplacer()
+
+	rep.Set("test1", "val1")
+	rep.Set("test2", "val2")
+	rep.Delete("test1")
+	rep.Delete("not-set")
+
+	if actual := rep.ReplaceAll("{test1}-{test2}", "?"); actual != "?-val2" {
+		t.Errorf("Expected '%s' got '%s'", "?-val2", actual)
+	}
+}
+
+func TestReplacerMap(t *testing.T) {
+	rep := NewReplacer().(*replacer)
+
+	rep.Map(func(key string) (string, bool) {
+		if key == "mapped" {
+			return "value", true
+		}
+		return "", false
+	})
+
+	if len(rep.providers) != 3 {
+		t.Fatalf("Expected providers length '%v' got length '%v'", 3, len(rep.providers))
+	}
+	if actual := rep.ReplaceAll("{mapped}", ""); actual != "value" {
+		t.Errorf("Expected '%s' got '%s'", "value", actual)
+	}
+}
+
+func TestReplacerReplaceAll(t *testing.T) {
+	// split our possible vars to two functions (to test if both functions are called)
+	var rep replacer
+	for _, mapFunc := range []ReplacementFunc{
+		func(key string) (val string, ok bool) {
+			switch key {
+			case "test1":
+				return "val1", true
+			case "asdf":
+				return "123", true
+			case "äöü":
+				return "öö_äü", true
+			case "with space":
+				return "space value", true
+			default:
+				return "NOOO", false
+			}
+		},
+		func(key string) (val string, ok bool) {
+			switch key {
+			case "aBcDeF":
+				return "611", true
+			case "ühätü":
+				return "0", true
+			case "":
+				return "empty", true
+			case "blank":
+				return "", true
+			default:
+				return "NOOO", false
+			}
+		},
+	} {
+		rep.Map(mapFunc)
+	}
+
+	for i, tc := range []struct {
+		testInput string
+		empty     string
+		expected  string
+	}{
+		{
+			// test vars without space
+			testInput: "{test1}{asdf}{äöü}{aBcDeF}{ühätü}",
+			expected:  "val1123öö_äü6110",
+		},
+		{
+			// test vars with space
+			testInput: "{test1} {asdf} {äöü} {aBcDeF} {ühätü} ",
+			expected:  "val1 123 öö_äü 611 0 ",
+		},
+		{
+			// test with empty val
+			testInput: "{test1} {with space} {}",
+			expected:  "val1 space value empty",
+		},
+		{
+			// unknown and blank values are substituted with empty
+			testInput: "{unknown}|{blank}|{test1}",
+			empty:     "-",
+			expected:  "-|-|val1",
+		},
+		{
+			// an unterminated placeholder is left as-is
+			testInput: "{test1}{asdf",
+			expected:  "val1{asdf",
+		},
+		{
+			// test nested vars
+			// with the current implementation nested vars are not supported as:
+			// - "te{test1}" will be tested as: "te{test1" (as the next closing bracket is used)
+			// - "as{{df{1}" will be tested as: "as{{df{1"
+			testInput: "{te{test1}{as{{df{1}",
+			expected:  "",
+		},
+		{
+			// no placeholders at all
+			testInput: "no placeholders }",
+			expected:  "no placeholders }",
+		},
+	} {
+		if actual := rep.ReplaceAll(tc.testInput, tc.empty); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s' for '%s'", i, tc.expected, actual, tc.testInput)
+		}
+	}
+}
+
+func TestComposeProviders(t *testing.T) {
+	first := func(key string) (string, bool) {
+		if key == "a" {
+			return "first", true
+		}
+		return "", false
+	}
+	second := func(key string) (string, bool) {
+		switch key {
+		case "a":
+			return "second", true
+		case "b":
+			return "", true
+		}
+		return "", false
+	}
+	composed := ComposeProviders(first, second)
+
+	for i, tc := range []struct {
+		key      string
+		expected string
+		ok       bool
+	}{
+		{key: "a", expected: "first", ok: true},
+		{key: "b", expected: "", ok: true},
+		{key: "c", expected: "", ok: false},
+	} {
+		if val, ok := composed(tc.key); val != tc.expected || ok != tc.ok {
+			t.Errorf("Test %d: Expected ('%s', %t) got ('%s', %t)", i, tc.expected, tc.ok, val, ok)
+		}
+	}
+}
+
+func TestLayeredProviderLayerOf(t *testing.T) {
+	layer := func(values map[string]string) ReplacementFunc {
+		return func(key string) (string, bool) {
+			val, ok := values[key]
+			return val, ok
+		}
+	}
+	lp := NewLayeredProvider(
+		ProviderLayer{Name: "flag", Provider: layer(map[string]string{"port": "8443"})},
+		ProviderLayer{Name: "env", Provider: layer(map[string]string{"port": "8080", "host": "example.com"})},
+		ProviderLayer{Name: "default", Provider: layer(map[string]string{"port": "80", "host": "localhost", "root": "/srv"})},
+	)
+
+	rep := NewReplacer()
+	rep.Map(lp.Replace)
+
+	if lp.LayerOf("port") != "" {
+		t.Errorf("Expected no layer before resolution, got '%s'", lp.LayerOf("port"))
+	}
+
+	actual := rep.ReplaceAll("{host}:{port}{root}{missing}", "")
+	if expected := "example.com:8443/srv"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	for _, tc := range []struct {
+		key   string
+		layer string
+	}{
+		{key: "port", layer: "flag"},
+		{key: "host", layer: "env"},
+		{key: "root", layer: "default"},
+		{key: "missing", layer: ""},
+	} {
+		if actual := lp.LayerOf(tc.key); actual != tc.layer {
+			t.Errorf("Expected layer '%s' for key '%s' got '%s'", tc.layer, tc.key, actual)
+		}
+	}
+}
+
+// mapProvider is an EnumerableProvider backed by a map.
+type mapProvider map[string]string
+
+func (m mapProvider) Replace(key string) (string, bool) {
+	val, ok := m[key]
+	return val, ok
+}
+
+func (m mapProvider) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestReplacerKeys(t *testing.T) {
+	rep := NewReplacer()
+	if keys := rep.Keys(); len(keys) != 0 {
+		t.Errorf("Expected no keys, got %v", keys)
+	}
+
+	rep.Set("site", "example.com")
+	rep.Set("upstream", "10.0.0.1")
+	rep.Set("admin", "root")
+	rep.Set("port", "443")
+	rep.Delete("upstream")
+
+	keys := rep.Keys()
+	if expected := []string{"admin", "port", "site"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %v got %v", expected, keys)
+	}
+
+	// the keys are a snapshot
+	rep.Set("zone", "a")
+	keys[0] = "changed"
+	if expected := []string{"admin", "port", "site", "zone"}; !reflect.DeepEqual(rep.Keys(), expected) {
+		t.Errorf("Expected keys %v got %v", expected, rep.Keys())
+	}
+}
+
+func TestReplacerMapEnumerable(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("static", "s")
+	rep.Set("shared", "from static")
+	rep.MapEnumerable(mapProvider{"enum1": "e1", "enum2": "", "shared": "from provider"})
+	rep.Map(func(key string) (string, bool) {
+		return "hidden", key == "not-enumerable"
+	})
+
+	expectedKeys := []string{"enum1", "enum2", "shared", "static"}
+	if keys := rep.Keys(); !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("Expected keys %v got %v", expectedKeys, keys)
+	}
+
+	expectedMap := map[string]string{
+		"enum1":  "e1",
+		"enum2":  "",
+		"shared": "from static",
+		"static": "s",
+	}
+	if m := rep.AsMap(); !reflect.DeepEqual(m, expectedMap) {
+		t.Errorf("Expected map %v got %v", expectedMap, m)
+	}
+
+	// the provider also resolves like any other
+	if actual := rep.ReplaceAll("{enum1}{not-enumerable}", ""); actual != "e1hidden" {
+		t.Errorf("Expected '%s' got '%s'", "e1hidden", actual)
+	}
+
+	var visited []string
+	rep.Range(func(key, val string) bool {
+		visited = append(visited, key)
+		return key != "enum2"
+	})
+	if expected := []string{"enum1", "enum2"}; !reflect.DeepEqual(visited, expected) {
+		t.Errorf("Expected Range to stop after %v, visited %v", expected, visited)
+	}
+}
+
+func TestReplacerResolveTyped(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("name", "caddy")
+	rep.MapTyped(func(key string) (interface{}, bool) {
+		switch key {
+		case "workers":
+			return int64(4), true
+		case "debug":
+			return true, true
+		case "name":
+			return 42, true
+		}
+		return nil, false
+	})
+	rep.Map(func(key string) (string, bool) {
+		return "plain", key == "plain"
+	})
+
+	for _, tc := range []struct {
+		key      string
+		expected interface{}
+		ok       bool
+	}{
+		{key: "workers", expected: int64(4), ok: true},
+		{key: "debug", expected: true, ok: true},
+		{key: "name", expected: "caddy", ok: true}, // static value wins
+		{key: "plain", expected: "plain", ok: true},
+		{key: "system.os", expected: runtime.GOOS, ok: true},
+		{key: "missing", expected: nil, ok: false},
+	} {
+		val, ok := rep.ResolveTyped(tc.key)
+		if ok != tc.ok || val != tc.expected {
+			t.Errorf("Expected (%#v, %t) for key '%s' got (%#v, %t)", tc.expected, tc.ok, tc.key, val, ok)
+		}
+	}
+
+	// typed values are still usable as strings
+	if actual := rep.ReplaceAll("{workers} {debug}", ""); actual != "4 true" {
+		t.Errorf("Expected '%s' got '%s'", "4 true", actual)
+	}
+}
+
+func TestReplacerSystemUser(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skipf("Current user not available: %v", err)
+	}
+	rep := NewReplacer()
+
+	if actual := rep.ReplaceAll("{system.user}", ""); actual != u.Username {
+		t.Errorf("Expected user '%s' got '%s'", u.Username, actual)
+	}
+
+	if runtime.GOOS == "windows" {
+		for _, key := range []string{"system.uid", "system.gid"} {
+			if val, ok := rep.(*replacer).get(key); ok {
+				t.Errorf("Expected '%s' to be unavailable on Windows, got '%s'", key, val)
+			}
+		}
+		return
+	}
+
+	for _, tc := range []struct {
+		key      string
+		expected int
+	}{
+		{key: "system.uid", expected: os.Getuid()},
+		{key: "system.gid", expected: os.Getgid()},
+	} {
+		val, ok := rep.(*replacer).get(tc.key)
+		if !ok || val != strconv.Itoa(tc.expected) {
+			t.Errorf("Expected '%d' for '%s' got '%s' (ok=%t)", tc.expected, tc.key, val, ok)
+		}
+	}
+}
+
+func TestRegisterGlobalReplacement(t *testing.T) {
+	defer func(registered []globalReplacement) {
+		globalReplacements = registered
+	}(globalReplacements)
+
+	before := NewReplacer()
+	RegisterGlobalReplacement(func(key string) (string, bool) {
+		return "v1.2.3", key == "plugin.version"
+	})
+	RegisterGlobalReplacement(func(key string) (string, bool) {
+		return "shadowed", key == "plugin.version" || key == "system.os"
+	})
+	if err := RegisterNamedGlobalReplacement("metrics", mapProvider{"plugin.metrics": "on"}.Replace); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := RegisterNamedGlobalReplacement("metrics", mapProvider{}.Replace); err == nil {
+		t.Error("Expected error registering a name twice")
+	}
+	if err := RegisterNamedGlobalReplacement("", mapProvider{}.Replace); err == nil {
+		t.Error("Expected error registering without a name")
+	}
+
+	rep := NewReplacer()
+	if actual := len(rep.(*replacer).providers); actual != 2 {
+		t.Errorf("Expected providers length '%v' got length '%v'", 2, actual)
+	}
+	rep.Map(mapProvider{"plugin.version": "mapped"}.Replace)
+
+	const input = "{plugin.version} {plugin.metrics} {system.os}"
+	expected := "v1.2.3 static " + runtime.GOOS
+	for _, r := range []Replacer{rep, before} {
+		r.Set("plugin.metrics", "static")
+		if actual := r.ReplaceAll(input, "-"); actual != expected {
+			t.Errorf("Expected '%s' got '%s'", expected, actual)
+		}
+	}
+	if actual, expected := NewReplacer().ReplaceAll("{plugin.metrics}", "-"), "on"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	if actual, expected := NewEmptyReplacer().ReplaceAll("{plugin.version}", "-"), "-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestNewEmptyReplacer(t *testing.T) {
+	os.Setenv("CADDY_REPLACER_TEST", "envtest")
+	defer os.Unsetenv("CADDY_REPLACER_TEST")
+
+	rep := NewEmptyReplacer()
+	if actual := len(rep.(*replacer).providers); actual != 0 {
+		t.Fatalf("Expected providers length '%v' got length '%v'", 0, actual)
+	}
+	const input = "{env.CADDY_REPLACER_TEST}|{system.os}|{file./etc/hostname}|{name}|{tenant}"
+	if actual, expected := rep.ReplaceAll(input, "-"), "-|-|-|-|-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	rep.Map(mapProvider{"name": "provided", "tenant": "acme"}.Replace)
+	rep.Set("name", "static")
+	if actual, expected := rep.ReplaceAll(input, "-"), "-|-|-|static|acme"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	if actual := len(rep.(*replacer).providers); actual != 2 {
+		t.Errorf("Expected providers length '%v' got length '%v'", 2, actual)
+	}
+}
+
+func TestNewFuncReplacer(t *testing.T) {
+	os.Setenv("CADDY_REPLACER_TEST", "envtest")
+	defer os.Unsetenv("CADDY_REPLACER_TEST")
+
+	var asked []string
+	rep := NewFuncReplacer(func(key string) (string, bool) {
+		asked = append(asked, key)
+		if key == "name" {
+			return "caddy", true
+		}
+		return "", false
+	})
+	rep.Set("static", "value")
+
+	actual := rep.ReplaceAll("{name}|{static}|{env.CADDY_REPLACER_TEST}|{system.os}", "-")
+	if expected := "caddy|-|-|-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	expected := []string{"name", "static", "env.CADDY_REPLACER_TEST", "system.os"}
+	if !reflect.DeepEqual(asked, expected) {
+		t.Errorf("Expected func to be asked for %v, got %v", expected, asked)
+	}
+	if keys := rep.Keys(); len(keys) != 0 {
+		t.Errorf("Expected no keys, got %v", keys)
+	}
+}
+
+func TestReplacerSystemUptime(t *testing.T) {
+	oldStart := processStart
+	defer func() { processStart = oldStart }()
+	processStart = time.Now().Add(-90 * time.Minute)
+
+	val := NewReplacer().ReplaceAll("{system.uptime}", "")
+	uptime, err := time.ParseDuration(val)
+	if err != nil {
+		t.Fatalf("Expected a duration, got '%s': %v", val, err)
+	}
+	if uptime < 90*time.Minute || uptime > 91*time.Minute {
+		t.Errorf("Expected uptime of about 90m, got %s", uptime)
+	}
+}
+
+func TestReplacerReplaceAllContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rep := NewReplacer()
+	rep.Set("a", "1")
+	rep.Set("c", "3")
+	rep.Map(func(key string) (string, bool) {
+		if key == "b" {
+			cancel() // deadline expires while rendering
+			return "2", true
+		}
+		return "", false
+	})
+
+	out, err := rep.ReplaceAllContext(ctx, "{a}-{b}-{c}-{a}", "")
+	if err != context.Canceled {
+		t.Errorf("Expected error %v, got %v", context.Canceled, err)
+	}
+	if expected := "1-2-{c}-{a}"; out != expected {
+		t.Errorf("Expected partial result '%s' got '%s'", expected, out)
+	}
+
+	// a context that is already done resolves nothing
+	out, err = rep.ReplaceAllContext(ctx, "{a}", "")
+	if err != context.Canceled || out != "{a}" {
+		t.Errorf("Expected ('%s', %v) got ('%s', %v)", "{a}", context.Canceled, out, err)
+	}
+
+	out, err = rep.ReplaceAllContext(context.Background(), "{a}-{c}", "")
+	if err != nil || out != "1-3" {
+		t.Errorf("Expected ('%s', nil) got ('%s', %v)", "1-3", out, err)
+	}
+}
+
+func TestReplacerCollapseUnknown(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("a", "1")
+	rep.Set("blank", "")
+	rep.CollapseUnknown("<missing>")
+
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "{x}{y}{z}", expected: "<missing>"},
+		{input: "{a}{x}{y}-{z}{a}", expected: "1<missing>-<missing>1"},
+		{input: "{x} {y}", expected: "<missing> <missing>"},
+		{input: "{x}{blank}{y}", expected: "<missing>-<missing>"},
+		{input: "{a}", expected: "1"},
+	} {
+		if actual := rep.ReplaceAll(tc.input, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	rep.CollapseUnknown("")
+	if actual := rep.ReplaceAll("{x}{y}", "-"); actual != "--" {
+		t.Errorf("Expected '%s' got '%s'", "--", actual)
+	}
+}
+
+func TestReplacerTransformUnknown(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("a", "1")
+	rep.Set("blank", "")
+	rep.CollapseUnknown("<missing>")
+	rep.TransformUnknown(func(placeholder string) string {
+		return "{{" + placeholder + "}}"
+	})
+
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "{a} {user.id}", expected: "1 {{user.id}}"},
+		{input: "{x}{y}", expected: "{{x}}{{y}}"},
+		{input: "{x|escape}", expected: "{{x|escape}}"},
+		{input: "{blank}{a}", expected: "-1"},
+	} {
+		if actual := rep.ReplaceAll(tc.input, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	rep.TransformUnknown(nil)
+	if actual := rep.ReplaceAll("{x}{y}", "-"); actual != "<missing>" {
+		t.Errorf("Expected '%s' got '%s'", "<missing>", actual)
+	}
+}
+
+func TestReplacerGetMany(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("a", "1")
+	rep.Set("b", "")
+
+	values, missing := rep.GetMany("a", "x", "b", "system.os", "y")
+	expectedValues := map[string]string{"a": "1", "b": "", "system.os": runtime.GOOS}
+	if !reflect.DeepEqual(values, expectedValues) {
+		t.Errorf("Expected values %v got %v", expectedValues, values)
+	}
+	if expected := []string{"x", "y"}; !reflect.DeepEqual(missing, expected) {
+		t.Errorf("Expected missing %v got %v", expected, missing)
+	}
+
+	values, missing = rep.GetMany()
+	if len(values) != 0 || len(missing) != 0 {
+		t.Errorf("Expected nothing for no keys, got %v and %v", values, missing)
+	}
+
+	// keys are resolved against one snapshot, so changes
+	// made while they are looked up are not seen
+	rep.Map(func(key string) (string, bool) {
+		if key != "touch" {
+			return "", false
+		}
+		rep.Set("a", "2")
+		rep.Set("c", "3")
+		return "touched", true
+	})
+	values, missing = rep.GetMany("touch", "a", "c")
+	expectedValues = map[string]string{"touch": "touched", "a": "1"}
+	if !reflect.DeepEqual(values, expectedValues) {
+		t.Errorf("Expected values %v got %v", expectedValues, values)
+	}
+	if expected := []string{"c"}; !reflect.DeepEqual(missing, expected) {
+		t.Errorf("Expected missing %v got %v", expected, missing)
+	}
+	if val, _ := rep.GetString("a"); val != "2" {
+		t.Errorf("Expected '%s' got '%s'", "2", val)
+	}
+
+	// deprecated names are mapped to their keys
+	rep.DeprecateKey("old.a", "a")
+	values, _ = rep.GetMany("old.a")
+	if expected := map[string]string{"old.a": "2"}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected values %v got %v", expected, values)
+	}
+}
+
+func TestReplacerUnresolvedChan(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("a", "1")
+
+	// nothing is recorded before the channel is requested
+	rep.ReplaceAll("{before}", "")
+
+	ch := rep.UnresolvedChan()
+	if rep.UnresolvedChan() != ch {
+		t.Errorf("Expected the same channel every time")
+	}
+
+	rep.ReplaceAll("{a}{x}{y|escape}", "")
+	rep.ReplaceAll("{x}", "")
+	for _, expected := range []string{"x", "y|escape", "x"} {
+		select {
+		case actual := <-ch:
+			if actual != expected {
+				t.Errorf("Expected '%s' got '%s'", expected, actual)
+			}
+		default:
+			t.Fatalf("Expected '%s' on the channel, but it was empty", expected)
+		}
+	}
+
+	// a full channel does not block rendering
+	for i := 0; i < unresolvedChanSize+10; i++ {
+		rep.ReplaceAll("{x}", "")
+	}
+	if len(ch) != unresolvedChanSize {
+		t.Errorf("Expected channel to be full with %d keys, got %d", unresolvedChanSize, len(ch))
+	}
+}
+
+func TestReplacerUnresolvedChanConcurrent(t *testing.T) {
+	rep := NewReplacer()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			rep.ReplaceAll("{missing}", "")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		ch := rep.UnresolvedChan()
+		for i := 0; i < 100; i++ {
+			select {
+			case <-ch:
+			default:
+			}
+		}
+	}()
+	wg.Wait()
+
+	rep.ReplaceAll("{missing}", "")
+	if len(rep.UnresolvedChan()) == 0 {
+		t.Error("Expected unresolved placeholders to be sent once the channel exists")
+	}
+}
+
+func TestReplacerAsExpandFunc(t *testing.T) {
+	os.Setenv("CADDY_REPLACER_HOST", "example.com")
+	defer os.Unsetenv("CADDY_REPLACER_HOST")
+
+	rep := NewReplacer()
+	rep.Set("port", "8080")
+
+	actual := os.Expand("https://${env.CADDY_REPLACER_HOST}:$port/${missing}{port}", rep.AsExpandFunc())
+	if expected := "https://example.com:8080/{port}"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestReplacerFuncMap(t *testing.T) {
+	os.Setenv("CADDY_REPLACER_HOST", "example.com")
+	defer os.Unsetenv("CADDY_REPLACER_HOST")
+
+	rep := NewReplacer()
+	rep.Set("port", "8080")
+
+	tpl, err := template.New("test").
+		Funcs(rep.FuncMap()).
+		Parse(`https://{{repl "env.CADDY_REPLACER_HOST|upper"}}:{{repl "port"}}/{{repl "missing"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := buf.String(), "https://EXAMPLE.COM:8080/"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestReplacerMapErr(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("ok", "fine")
+	rep.MapErr(func(key string) (string, bool, error) {
+		if key == "secret" {
+			return "", false, fmt.Errorf("store unavailable")
+		}
+		return "", false, nil
+	})
+	rep.Map(func(key string) (string, bool) {
+		if key == "secret" {
+			return "shadowed", true
+		}
+		return "", false
+	})
+
+	actual, err := rep.ReplaceAllErr("{ok} {secret}", "-")
+	if expected := "fine -"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	if expected := "{secret}: store unavailable"; err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s' got '%v'", expected, err)
+	}
+	if _, ok := rep.ResolveTyped("secret"); ok {
+		t.Error("Expected failing key to be unknown to ResolveTyped")
+	}
+}
+
+func TestReplacerReplaceBestEffort(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("name", "caddy")
+	rep.Set("blank", "")
+	rep.Set("bad", "100%")
+	rep.MapErr(func(key string) (string, bool, error) {
+		if key == "remote" {
+			return "", false, fmt.Errorf("timeout")
+		}
+		return "", false, nil
+	})
+
+	actual, errs := rep.ReplaceBestEffort("{name}:{unknown}:{remote|escape}:{bad|unescape}:[{blank}]:{name|nope}:{open")
+	if expected := "caddy:{unknown}:{remote|escape}:{bad|unescape}:[]:{name|nope}:{open"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	expected := []string{
+		"{remote|escape}: timeout",
+		`{bad|unescape}: invalid URL escape "%"`,
+		"{name|nope}: unknown modifier 'nope'",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected errors %q got %q", expected, messages)
+	}
+
+	if actual, errs := rep.ReplaceBestEffort("{name} {unknown}"); actual != "caddy {unknown}" || len(errs) != 0 {
+		t.Errorf("Expected '%s' and no errors got '%s' and %v", "caddy {unknown}", actual, errs)
+	}
+}
+
+func TestReplacerHostnameForms(t *testing.T) {
+	oldHostname, oldLookup := hostname, lookupCNAME
+	defer func() { hostname, lookupCNAME = oldHostname, oldLookup }()
+
+	for i, tc := range []struct {
+		name      string
+		cname     string
+		lookupErr error
+		short     string
+		fqdn      string
+	}{
+		{name: "web1.example.com", cname: "web1.example.com.", short: "web1", fqdn: "web1.example.com"},
+		{name: "web1", cname: "web1.corp.example.com.", short: "web1", fqdn: "web1.corp.example.com"},
+		{name: "a.b.c", lookupErr: fmt.Errorf("no such host"), short: "a", fqdn: "a.b.c"},
+		{name: "web1", cname: ".", short: "web1", fqdn: "web1"},
+	} {
+		hostname = func() (string, error) { return tc.name, nil }
+		lookupCNAME = func(host string) (string, error) {
+			if host != tc.name {
+				t.Errorf("Test %d: Expected lookup of '%s' got '%s'", i, tc.name, host)
+			}
+			return tc.cname, tc.lookupErr
+		}
+
+		rep := NewReplacer()
+		input := "{system.hostname} {system.hostname.short} {system.hostname.fqdn}"
+		expected := tc.name + " " + tc.short + " " + tc.fqdn
+		if actual := rep.ReplaceAll(input, "-"); actual != expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, expected, actual)
+		}
+	}
+}
+
+func TestReplacerTimeNow(t *testing.T) {
+	oldNow := now
+	defer func() { now = oldNow }()
+	now = func() time.Time {
+		return time.Date(2018, 9, 14, 17, 3, 7, 250000000, time.FixedZone("CEST", 2*60*60))
+	}
+
+	rep := NewReplacer()
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "{time.now}", expected: "2018-09-14T17:03:07+02:00"},
+		{input: "{time.now.unix}", expected: "1536937387"},
+		{input: "{time.now.unix_ms}", expected: "1536937387250"},
+		{input: "{time.now.http}", expected: "Fri, 14 Sep 2018 15:03:07 GMT"},
+		{input: "{time.now.format:2006-01-02}", expected: "2018-09-14"},
+		{input: "{time.now.format:15:04:05}", expected: "17:03:07"},
+		{input: "{time.now.format:Jan 2|upper}", expected: "SEP 14"},
+		{input: "{time.now.year}", expected: "-"},
+		{input: "{time.nowhere}", expected: "-"},
+	} {
+		if actual := rep.ReplaceAll(tc.input, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// the real clock gives a parseable timestamp
+	now = oldNow
+	if _, err := time.Parse(time.RFC3339, rep.ReplaceAll("{time.now}", "")); err != nil {
+		t.Errorf("Expected an RFC 3339 timestamp: %v", err)
+	}
+}
+
+// countingResolver is a Resolver backed by a map
+// which records the keys it was asked for.
+type countingResolver struct {
+	values map[string]string
+	asked  []string
+}
+
+func (c *countingResolver) Resolve(key string) (string, bool) {
+	c.asked = append(c.asked, key)
+	val, ok := c.values[key]
+	return val, ok
+}
+
+func TestNewReplacerFromResolver(t *testing.T) {
+	res := &countingResolver{values: map[string]string{"db.host": "10.0.0.5", "db.port": "5432"}}
+	rep := NewReplacerFromResolver(res)
+
+	if actual, expected := rep.ReplaceAll("{db.host}:{db.port}/{system.os}", "-"), "10.0.0.5:5432/-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	if expected := []string{"db.host", "db.port", "system.os"}; !reflect.DeepEqual(res.asked, expected) {
+		t.Errorf("Expected resolver to be asked for %v got %v", expected, res.asked)
+	}
+}
+
+func TestNewChainReplacer(t *testing.T) {
+	global := NewFuncReplacer(mapProvider{"scope": "global", "port": "80", "admin": "root"}.Replace)
+	site := NewFuncReplacer(mapProvider{"scope": "site", "port": "8080"}.Replace)
+	route := NewFuncReplacer(mapProvider{"scope": "route"}.Replace)
+	rep := NewChainReplacer(route, site, global)
+
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "{scope}", expected: "route"},
+		{input: "{port}", expected: "8080"},
+		{input: "{admin|upper}", expected: "ROOT"},
+		{input: "{missing}", expected: "-"},
+		{input: "{missing:fallback}", expected: "fallback"},
+	} {
+		if actual := rep.ReplaceAll(tc.input, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// scopes are consulted at replace-time
+	scoped := NewReplacer()
+	rep = NewChainReplacer(scoped, global)
+	scoped.Set("scope", "late")
+	if actual, expected := rep.ReplaceAll("{scope}/{port}", "-"), "late/80"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestReplacerReplaceAllSimple(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Set("blank", "")
+
+	for i, input := range []string{
+		"https://{host}/",
+		"[{blank}]",
+		"{host|upper} {missing:fallback}",
+		"\\{host\\} {host",
+		"no placeholders",
+	} {
+		if actual, expected := rep.ReplaceAllSimple(input), rep.ReplaceAll(input, ""); actual != expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, expected, actual)
+		}
+	}
+
+	// unknown placeholders are left as they are
+	if actual, expected := rep.ReplaceAllSimple("{host}/{missing}"), "example.com/{missing}"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestReplacerReplaceRange(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("a", "1")
+	rep.Set("b", "2")
+	rep.Set("c", "3")
+
+	const input = "{a} {b} \\{c\\} {c}"
+	for i, tc := range []struct {
+		start, end int
+		expected   string
+	}{
+		{start: 0, end: len(input), expected: "1 2 {c} 3"},
+		{start: 0, end: 3, expected: "1 {b} \\{c\\} {c}"},
+		{start: 4, end: 7, expected: "{a} 2 \\{c\\} {c}"},
+		{start: 4, end: 8, expected: "{a} 2 \\{c\\} {c}"},
+		{start: 8, end: 14, expected: "{a} {b} {c} {c}"},
+		{start: 1, end: 6, expected: "{a} {b} \\{c\\} {c}"},
+		{start: 2, end: 100, expected: "{a} 2 {c} 3"},
+		{start: -5, end: 5, expected: "1 {b} \\{c\\} {c}"},
+		{start: 5, end: 5, expected: input},
+		{start: 7, end: 3, expected: input},
+	} {
+		if actual := rep.ReplaceRange(input, tc.start, tc.end, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// values expanded in range are expanded entirely
+	rep.Set("nested", "{a}{b}")
+	rep.EnableRecursion(DefaultExpandDepth)
+	if actual, expected := rep.ReplaceRange("{a} {nested}", 4, 12, "-"), "{a} 12"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestReplacerReplaceAllTo(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Set("blank", "")
+
+	var buf bytes.Buffer
+	for i, input := range []string{
+		"https://{host}/",
+		"no placeholders",
+		"{blank}{unknown}|{host|nope}",
+		"{host}{open",
+		"",
+	} {
+		buf.WriteString("prefix:")
+		rep.ReplaceAllTo(&buf, input, "-")
+		if actual, expected := buf.String(), "prefix:"+rep.ReplaceAll(input, "-"); actual != expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, expected, actual)
+		}
+		buf.Reset()
+	}
+}
+
+func BenchmarkReplacerReplaceAllTo(b *testing.B) {
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Set("port", "443")
+	const input = "https://{host}:{port}/"
+
+	b.Run("ReplaceAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rep.ReplaceAll(input, "")
+		}
+	})
+	b.Run("ReplaceAllTo", func(b *testing.B) {
+		var buf bytes.Buffer
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			rep.ReplaceAllTo(&buf, input, "")
+		}
+	})
+}
+
+func TestReplacerCaddyDirs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("platform directories are only tested on linux")
+	}
+	vars := []string{"CADDYPATH", "HOME", "XDG_CONFIG_HOME"}
+	for _, name := range vars {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Setenv(name, "")
+	}
+	os.Setenv("HOME", "/home/caddy")
+
+	rep := NewReplacer()
+	const input = "{caddy.data_dir}|{caddy.config_dir}"
+	for i, tc := range []struct {
+		env      map[string]string
+		expected string
+	}{
+		{
+			expected: "/home/caddy/.caddy|/home/caddy/.config/caddy",
+		},
+		{
+			env:      map[string]string{"XDG_CONFIG_HOME": "/xdg/config"},
+			expected: "/home/caddy/.caddy|/xdg/config/caddy",
+		},
+		{
+			env:      map[string]string{"CADDYPATH": "/var/lib/caddy"},
+			expected: "/var/lib/caddy|/home/caddy/.config/caddy",
+		},
+		{
+			env:      map[string]string{"HOME": "", "CADDYPATH": "/var/lib/caddy"},
+			expected: "/var/lib/caddy|-",
+		},
+		{
+			env:      map[string]string{"HOME": ""},
+			expected: "-|-",
+		},
+	} {
+		for name, val := range tc.env {
+			os.Setenv(name, val)
+		}
+		if actual := rep.ReplaceAll(input, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+		for _, name := range vars {
+			os.Setenv(name, "")
+		}
+		os.Setenv("HOME", "/home/caddy")
+	}
+}
+
+func TestReplacerReplaceAllWith(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Set("port", "80")
+	rep.EnableCommandDefaults(func(name string, args ...string) (string, error) {
+		return "from command", nil
+	}, "echo")
+
+	overlay := map[string]string{
+		"port":      "8443",
+		"tenant":    "acme",
+		"system.os": "overlaid",
+		"path":      "/a b",
+	}
+	input := "{host}:{port} {tenant} {system.os} {path|escape} {tenant:$(echo x)}"
+	expected := "example.com:8443 acme overlaid %2Fa%20b acme"
+	if actual := rep.ReplaceAllWith(input, "-", overlay); actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	// the overlay does not persist
+	if actual, expected := rep.ReplaceAll("{host}:{port} {tenant}", "-"), "example.com:80 -"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	if actual, expected := rep.ReplaceAllWith("{port}", "-", nil), "80"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestReplacerMapIf(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("app", "shop")
+
+	var tenant string
+	rep.MapIf(func() bool { return tenant != "" }, func(key string) (string, bool) {
+		switch key {
+		case "tenant.id":
+			return tenant, true
+		case "app":
+			return "tenant app", true
+		}
+		return "", false
+	})
+
+	const input = "{app}/{tenant.id}"
+	if actual, expected := rep.ReplaceAll(input, "-"), "shop/-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	tenant = "acme"
+	if actual, expected := rep.ReplaceAll(input, "-"), "shop/acme"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	tenant = ""
+	if actual, expected := rep.ReplaceAll(input, "-"), "shop/-"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestReplacerMapNamed(t *testing.T) {
+	rep := NewReplacer()
+	rep.MapNamed("tenant", mapProvider(map[string]string{"tenant.id": "acme", "region": "eu"}).Replace)
+	rep.MapNamed("region", mapProvider(map[string]string{"region": "us", "zone": "a"}).Replace)
+
+	const input = "{tenant.id}/{region}/{zone}"
+	if actual, expected := rep.ReplaceAll(input, "-"), "acme/eu/a"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	rep.RemoveMapping("tenant")
+	if actual, expected := rep.ReplaceAll(input, "-"), "-/us/a"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	// mapping the same name again replaces the provider
+	rep.MapNamed("region", mapProvider(map[string]string{"zone": "b"}).Replace)
+	if actual, expected := rep.ReplaceAll(input, "-"), "-/-/b"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	// unnamed providers are never removed
+	rep.RemoveMapping("")
+	rep.RemoveMapping("missing")
+	if actual, expected := len(rep.(*replacer).providers), 3; actual != expected {
+		t.Errorf("Expected %d providers got %d", expected, actual)
+	}
+}
+
+func TestReplacerMapPriority(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("site", "example.com")
+	rep.Map(mapProvider{"user": "global", "region": "eu"}.Replace)
+	rep.MapPriority(mapProvider{"user": "fallback", "zone": "fallback"}.Replace, -1)
+	rep.MapPriority(mapProvider{"user": "request", "site": "override"}.Replace, 10)
+	rep.MapPriority(mapProvider{"user": "later", "region": "us"}.Replace, 10)
+	rep.Map(mapProvider{"zone": "a"}.Replace)
+
+	const input = "{user}|{site}|{region}|{zone}"
+	if actual, expected := rep.ReplaceAll(input, "-"), "request|override|us|a"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	if val, ok := rep.GetString("user"); !ok || val != "request" {
+		t.Errorf("Expected '%s' got '%s' (ok=%t)", "request", val, ok)
+	}
+}
+
+func TestReplacerMapPriorityAndNamed(t *testing.T) {
+	rep := NewReplacer()
+	rep.MapPriority(mapProvider{"tier": "low", "zone": "low"}.Replace, -1)
+	rep.MapNamed("tenant", mapProvider{"tier": "named"}.Replace)
+	rep.MapPriority(mapProvider{"zone": "high"}.Replace, 1)
+
+	const input = "{tier}|{zone}"
+	if actual, expected := rep.ReplaceAll(input, "-"), "named|high"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	// a replaced named provider keeps its place
+	rep.MapNamed("tenant", mapProvider{"tier": "renamed", "zone": "renamed"}.Replace)
+	if actual, expected := rep.ReplaceAll(input, "-"), "renamed|high"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestReplacerClone(t *testing.T) {
+	parent := NewReplacer()
+	parent.Set("site", "example.com")
+	parent.Set("port", "443")
+	parent.MapNamed("tenant", mapProvider{"tenant.id": "acme"}.Replace)
+	parent.RememberKey("port", 1)
+	parent.ReplaceAll("{port}", "")
+
+	clone := parent.Clone()
+	clone.Set("site", "child.example.com")
+	clone.Delete("port")
+	clone.Set("route", "/api")
+	clone.RemoveMapping("tenant")
+	clone.Map(mapProvider{"extra": "yes"}.Replace)
+
+	const input = "{site}|{port}|{route}|{tenant.id}|{extra}|{system.os}"
+	if actual, expected := parent.ReplaceAll(input, "-"), "example.com|443|-|acme|-|"+runtime.GOOS; actual != expected {
+		t.Errorf("Expected parent to render '%s' got '%s'", expected, actual)
+	}
+	if actual, expected := clone.ReplaceAll(input, "-"), "child.example.com|-|/api|-|yes|"+runtime.GOOS; actual != expected {
+		t.Errorf("Expected clone to render '%s' got '%s'", expected, actual)
+	}
+
+	// histories are separate too
+	clone.Set("port", "8443")
+	clone.ReplaceAll("{port}", "")
+	if actual, expected := clone.ReplaceAll("{port.prev}", "-"), "443"; actual != expected {
+		t.Errorf("Expected clone to render '%s' got '%s'", expected, actual)
+	}
+	if actual, expected := parent.ReplaceAll("{port.prev}", "-"), "-"; actual != expected {
+		t.Errorf("Expected parent to render '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestReplacerKeyDefault(t *testing.T) {
+	os.Unsetenv("CADDY_UNSET_PORT")
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Set("blank", "")
+	rep.Set("urn:isbn", "0451450523")
+
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "{env.CADDY_UNSET_PORT:8080}", expected: "8080"},
+		{input: "{missing:localhost}", expected: "localhost"},
+		{input: "{host:localhost}", expected: "example.com"},
+		{input: "[{blank:fallback}]", expected: "[-]"},
+		{input: "{urn:isbn}", expected: "0451450523"},
+		{input: "{missing:http://localhost:2015}", expected: "http://localhost:2015"},
+		{input: "{missing:a/b|escape}", expected: "a%2Fb"},
+		{input: "{missing:x|required}", expected: "x"},
+		{input: "{missing:$(echo hi)}", expected: "-"},
+		{input: "{missing}", expected: "-"},
+	} {
+		if actual := rep.ReplaceAll(tc.input, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// an empty default is an empty value
+	if val, ok, err := rep.(*replacer).resolve("missing:"); val != "" || !ok || err != nil {
+		t.Errorf("Expected empty value for empty default, got '%s' (ok=%t, err=%v)", val, ok, err)
+	}
+	if actual := rep.ReplaceAll("[{missing:}]", ""); actual != "[]" {
+		t.Errorf("Expected '%s' got '%s'", "[]", actual)
+	}
+}
+
+func TestReplacerReplaceKnown(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("test1", "val1")
+	rep.Set("blank", "")
+	rep.Set("bad", "100%")
+
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "{test1} {later.stage}", expected: "val1 {later.stage}"},
+		{input: "{unknown}|{blank}|{test1}", expected: "{unknown}|-|val1"},
+		{input: "{test1}{asdf", expected: "val1{asdf"},
+		{input: "{te{test1}{as{{df{1}", expected: "{te{test1}{as{{df{1}"},
+		{input: "{unknown|escape}", expected: "{unknown|escape}"},
+		{input: "{bad|unescape}", expected: "100%"},
+		{input: "{}", expected: "{}"},
+		{input: "{test1:x} {later:x}", expected: "val1 {later:x}"},
+		{input: "{test1:x|upper} {later:x|upper}", expected: "VAL1 {later:x|upper}"},
+	} {
+		if actual := rep.ReplaceKnown(tc.input, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// a second stage resolves what the first one left
+	second := NewReplacer()
+	second.Set("later.stage", "done")
+	if actual := second.ReplaceAll(rep.ReplaceKnown("{test1}/{later.stage}", ""), ""); actual != "val1/done" {
+		t.Errorf("Expected '%s' got '%s'", "val1/done", actual)
+	}
+
+	// and the last stage applies the defaults of the keys
+	// that no stage knows
+	const staged = "{later.stage:x}/{never:y}"
+	if actual := second.ReplaceAll(rep.ReplaceKnown(staged, ""), ""); actual != "done/y" {
+		t.Errorf("Expected '%s' got '%s'", "done/y", actual)
+	}
+}
+
+func TestReplacerReplaceOrErr(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Set("blank", "")
+
+	for i, tc := range []struct {
+		input        string
+		errOnEmpty   bool
+		errOnUnknown bool
+		expected     string
+		message      string
+	}{
+		{input: "{host}/{blank}/{typo}", expected: "example.com//"},
+		{input: "{host}/{blank}", errOnEmpty: true, errOnUnknown: true, expected: "example.com/",
+			message: "{blank} at offset 7: empty value"},
+		{input: "{host} {sytem.hostname|escape}", errOnUnknown: true, expected: "example.com ",
+			message: "{sytem.hostname|escape} at offset 7: unknown key 'sytem.hostname'"},
+		{input: "{blank} {typo}", errOnUnknown: true, expected: " ",
+			message: "{typo} at offset 8: unknown key 'typo'"},
+		{input: "{blank} {typo}", errOnEmpty: true, expected: " ",
+			message: "{blank} at offset 0: empty value"},
+		{input: "{typo} {blank}", errOnEmpty: true, errOnUnknown: true, expected: " ",
+			message: "{typo} at offset 0: unknown key 'typo'"},
+		{input: "{typo:fallback} {host}", errOnEmpty: true, errOnUnknown: true, expected: "fallback example.com"},
+	} {
+		actual, err := rep.ReplaceOrErr(tc.input, tc.errOnEmpty, tc.errOnUnknown)
+		if actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+		if tc.message == "" && err != nil {
+			t.Errorf("Test %d: Expected no error, got: %v", i, err)
+		}
+		if tc.message != "" && (err == nil || err.Error() != tc.message) {
+			t.Errorf("Test %d: Expected error '%s' got '%v'", i, tc.message, err)
+		}
+	}
+}
+
+func TestReplacerEscapedBraces(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("test1", "val1")
+	rep.Set("name", "caddy")
+
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: `\{test1\}`, expected: "{test1}"},
+		{input: `\{{test1}\}`, expected: "{val1}"},
+		{input: `{name}\{{test1}}`, expected: "caddy{val1}"},
+		{input: `\{not a placeholder} {name}`, expected: "{not a placeholder} caddy"},
+		{input: `C:\\{name}`, expected: `C:\caddy`},
+		{input: `\\\{name\}`, expected: `\{name}`},
+		{input: `a\b \n`, expected: `a\b \n`},
+		{input: `trailing\`, expected: `trailing\`},
+		{input: `\\`, expected: `\`},
+		{input: "{te{test1}{as{{df{1}", expected: ""},
+		{input: `{test1}{asdf \{x`, expected: "val1{asdf {x"},
+		{input: `{name|trimprefix \}`, expected: "caddy"},
+	} {
+		if actual := rep.ReplaceAll(tc.input, ""); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// escapes survive a stage that leaves placeholders for later
+	if actual, expected := rep.ReplaceKnown(`\{{name}\} {later}`, ""), `\{caddy\} {later}`; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestReplacerRecursion(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("url", "https://{host}{path}")
+	rep.Set("host", "{name}.{domain}")
+	rep.Set("name", "www")
+	rep.Set("domain", "example.com")
+	rep.Set("path", "/")
+	rep.Set("a", "a->{b}")
+	rep.Set("b", "b->{a}")
+	rep.Set("self", "<{self}>")
+	rep.Set("html", "<{amp}>")
+	rep.Set("amp", "&")
+	rep.Set("slash", "{path|escape}")
+	lit := rep.Literal("{name}")
+
+	// without recursion, values are used as-is
+	if actual, expected := rep.ReplaceAll("{url}", ""), "https://{host}{path}"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	rep.EnableRecursion(0)
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "{url}", expected: "https://www.example.com/"},
+		{input: "{a}", expected: "a->b->{a}"},
+		{input: "{b}", expected: "b->a->{b}"},
+		{input: "{self}|{self}", expected: "<{self}>|<{self}>"},
+		{input: lit, expected: "{name}"},
+		{input: "{slash}", expected: "%2F"},
+	} {
+		if actual := rep.ReplaceAll(tc.input, ""); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	// values are escaped once, after expansion
+	if actual, expected := rep.ReplaceAllEscaped("{html}", "", "text/html"), "&lt;&amp;&gt;"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+
+	// values deeper than the limit are used as-is
+	rep.EnableRecursion(1)
+	if actual, expected := rep.ReplaceAll("{url}", ""), "https://{name}.{domain}/"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+}
+
+func TestReplacerGet(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("braces", "{not|parsed}")
+	rep.Set("shadow", "static")
+	rep.Set("not|parsed", "should not be used")
+	rep.Map(func(key string) (string, bool) {
+		return "provider", key == "shadow" || key == "mapped"
+	})
+	rep.MapTyped(func(key string) (interface{}, bool) {
+		return 42, key == "answer"
+	})
+	rep.DeprecateKey("old", "shadow")
+	rep.DeprecateKey("question", "answer")
+
+	for _, tc := range []struct {
+		key      string
+		expected interface{}
+		ok       bool
+	}{
+		{key: "braces", expected: "{not|parsed}", ok: true},
+		{key: "old", expected: "static", ok: true},
+		{key: "question", expected: 42, ok: true},
+		{key: "shadow", expected: "static", ok: true},
+		{key: "mapped", expected: "provider", ok: true},
+		{key: "answer", expected: 42, ok: true},
+		{key: "system.os", expected: runtime.GOOS, ok: true},
+		{key: "mapped|escape", ok: false},
+		{key: "missing:default", ok: false},
+		{key: "{braces}", ok: false},
+	} {
+		val, ok := rep.Get(tc.key)
+		if ok != tc.ok || (ok && val != tc.expected) {
+			t.Errorf("Get(%q): Expected %v (ok=%t) got %v (ok=%t)", tc.key, tc.expected, tc.ok, val, ok)
+		}
+		str, ok := rep.GetString(tc.key)
+		if ok != tc.ok || (ok && str != fmt.Sprint(tc.expected)) {
+			t.Errorf("GetString(%q): Expected '%v' (ok=%t) got '%s' (ok=%t)", tc.key, tc.expected, tc.ok, str, ok)
+		}
+	}
+
+	// typed lookups are observed like others
+	sink := new(fakeSink)
+	rep.SetMetricsSink(sink)
+	rep.Get("answer")
+	rep.Get("missing")
+	if len(sink.observations) != 2 || !sink.observations[0].ok || sink.observations[1].ok {
+		t.Errorf("Expected a hit and a miss to be observed, got %v", sink.observations)
+	}
+}
+
+func TestReplacerConcurrentUse(t *testing.T) {
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := fmt.Sprintf("w%d.%d", i, j)
+				rep.Set(key, "v")
+				rep.Delete(key)
+				if j%50 == 0 {
+					rep.Map(func(key string) (string, bool) { return "", false })
+					rep.Literal("{x}")
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if actual := rep.ReplaceAll("{host}/{w0.1}", ""); !strings.HasPrefix(actual, "example.com/") {
+					t.Errorf("Expected output to start with '%s', got '%s'", "example.com/", actual)
+				}
+				rep.Get("host")
+				rep.Keys()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNewReplacerWithDelims(t *testing.T) {
+	rep := NewReplacerWithDelims("[[", "]]")
+	rep.Set("host", "example.com")
+	rep.Set("blank", "")
+	rep.Set("name", "{literal braces}")
+
+	for i, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{input: "[[host]]", expected: "example.com"},
+		{input: "{host} [[host]]", expected: "{host} example.com"},
+		{input: "[x] [[host]] [y]", expected: "[x] example.com [y]"},
+		{input: "[[[host]]]", expected: "-]"},
+		{input: "[[host]]]", expected: "example.com]"},
+		{input: "a [ [[host]]", expected: "a [ example.com"},
+		{input: "[[unknown]]|[[blank]]", expected: "-|-"},
+		{input: "[[host|upper]] [[missing:fallback]]", expected: "EXAMPLE.COM fallback"},
+		{input: "[[host]] [[open", expected: "example.com [[open"},
+		{input: `\[[host]] \]] [[name]]`, expected: "[[host]] ]] {literal braces}"},
+		{input: "[[host] ] [[host]]", expected: "-"},
+	} {
+		if actual := rep.ReplaceAll(tc.input, "-"); actual != tc.expected {
+			t.Errorf("Test %d: Expected '%s' got '%s'", i, tc.expected, actual)
+		}
+	}
+
+	if actual, expected := rep.ReplaceKnown("[[host]] [[later]] {x}", ""), "example.com [[later]] {x}"; actual != expected {
+		t.Errorf("Expected '%s' got '%s'", expected, actual)
+	}
+	if lit := rep.Literal("[[host]]"); rep.ReplaceAll(lit, "") != "[[host]]" {
+		t.Errorf("Expected literal '%s' to render as-is, got '%s'", lit, rep.ReplaceAll(lit, ""))
+	}
+	if actual := Resolvability("[[host]] [[nope]] {x}", rep); len(actual) != 2 || !actual[0].Resolvable || actual[1].Resolvable {
+		t.Errorf("Expected resolvable [[host]] and unresolvable [[nope]], got %+v", actual)
+	}
+
+	// the default delimiters are braces
+	if actual := NewReplacerWithDelims("", "").ReplaceAll("{system.os}", ""); actual != runtime.GOOS {
+		t.Errorf("Expected '%s' got '%s'", runtime.GOOS, actual)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import "github.com/caddyserver/caddy/caddyfile"
+
+// ReplaceTokens returns a copy of tokens with the placeholders
+// in the text of each token replaced by rep. Because tokens are
+// replaced one at a time, a brace in one token can never pair
+// with a brace in another: the braces that open and close
+// blocks are left alone, and a placeholder within a quoted
+// string, which the lexer turns into a single token, resolves
+// as a whole even if it contains spaces. Placeholders that rep
+// does not recognize, like the {host} and {path} placeholders
+// that are only known when a request is served, are left as
+// they are, and so are escaped braces, for the replacers that
+// run later.
+//
+// The Caddyfile lexer has no heredocs, so there are no tokens
+// whose text should be exempt from replacement.
+func ReplaceTokens(tokens []caddyfile.Token, rep Replacer) []caddyfile.Token {
+	replaced := make([]caddyfile.Token, len(tokens))
+	for i, tok := range tokens {
+		if tok.Text != phOpen && tok.Text != phClose {
+			tok.Text = rep.ReplaceKnown(tok.Text, "")
+		}
+		replaced[i] = tok
+	}
+	return replaced
+}
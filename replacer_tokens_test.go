@@ -0,0 +1,62 @@
+// Copyright 2015 Light Code Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/caddyfile"
+)
+
+func TestReplaceTokens(t *testing.T) {
+	input := `{host}:80 {
+	header / X-Greeting "hello {user name}, {unknown}"
+	respond "{" }
+	root {root}
+	redir {scheme}://{host2}{uri}
+}`
+	d := caddyfile.NewDispenser("Caddyfile", strings.NewReader(input))
+	var tokens []caddyfile.Token
+	for d.Next() {
+		tokens = append(tokens, caddyfile.Token{File: d.File(), Line: d.Line(), Text: d.Val()})
+	}
+	original := append([]caddyfile.Token(nil), tokens...)
+
+	rep := NewReplacer()
+	rep.Set("host", "example.com")
+	rep.Set("user name", "Jane Doe")
+	rep.Set("root", "/srv/www")
+
+	var actual []string
+	for _, tok := range ReplaceTokens(tokens, rep) {
+		actual = append(actual, tok.Text)
+	}
+	expected := []string{
+		"example.com:80", "{",
+		"header", "/", "X-Greeting", "hello Jane Doe, {unknown}",
+		"respond", "{", "}",
+		"root", "/srv/www",
+		"redir", "{scheme}://{host2}{uri}",
+		"}",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected tokens %q got %q", expected, actual)
+	}
+	if !reflect.DeepEqual(tokens, original) {
+		t.Error("Expected input tokens to be left unchanged")
+	}
+}